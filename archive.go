@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The tests of an archive are extracted into a temporary directory when the
+// archive is listed, in one pass over it, and stay there until the run ends:
+// -dedup, -reduce-dir and the cache read them after they ran.
+var archiveDir string
+
+// archiveNames maps the directories of the extracted archives to the base
+// names of the archives.
+var archiveNames = make(map[string]string)
+
+func isArchive(filename string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTestFile reports whether an archive entry is a test.
+func isTestFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".bc" || ext == ".ll"
+}
+
+// memberPath returns where the archive entry is extracted in dir. Entries
+// which would land outside of dir are rejected.
+func memberPath(dir, name string) (string, os.Error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("the entry %s is outside of the archive", name)
+	}
+	return path.Join(dir, clean), nil
+}
+
+// extractMember writes an entry of the archive to the file named test.
+func extractMember(test string, r io.Reader) (err os.Error) {
+	if err = os.MkdirAll(path.Dir(test), 0755); err != nil {
+		return
+	}
+	var out *os.File
+	if out, err = os.Create(test); err != nil {
+		return
+	}
+	defer out.Close()
+	_, err = io.Copy(out, r)
+	return
+}
+
+// expandArchive extracts the tests in the archive into a temporary directory
+// and returns their paths there.
+func expandArchive(filename string) (tests []string, err os.Error) {
+	if archiveDir == "" {
+		if archiveDir, err = ioutil.TempDir("", "llvm-side-by-side-archives"); err != nil {
+			return
+		}
+	}
+	var dirs []string
+	if dirs, err = filepath.Glob(path.Join(archiveDir, "*")); err != nil {
+		return
+	}
+	dir := path.Join(archiveDir, strconv.Itoa(len(dirs)))
+	archiveNames[dir] = path.Base(filename)
+	add := func(name string, r io.Reader) (err os.Error) {
+		if !isTestFile(name) {
+			return
+		}
+		var test string
+		if test, err = memberPath(dir, name); err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		if err = extractMember(test, r); err != nil {
+			return
+		}
+		tests = append(tests, test)
+		return
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	if filepath.Ext(filename) == ".zip" {
+		var zr *zip.ReadCloser
+		if zr, err = zip.OpenReader(filename); err != nil {
+			return
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			var r io.ReadCloser
+			if r, err = f.Open(); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", filename, f.Name, err)
+			}
+			err = add(f.Name, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(filename); err != nil {
+		return
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(filename, ".tar") {
+		if r, err = gzip.NewReader(f); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	tr := tar.NewReader(r)
+	for {
+		var hdr *tar.Header
+		if hdr, err = tr.Next(); err == os.EOF {
+			return tests, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			if err = add(hdr.Name, tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+// inputSize returns the size of the test.
+func inputSize(test string) (size int64, err os.Error) {
+	var fi *os.FileInfo
+	if fi, err = os.Stat(test); err != nil {
+		return
+	}
+	return fi.Size, nil
+}
+
+// cleanupArchives removes the directories of the extracted tests.
+func cleanupArchives() {
+	if archiveDir != "" {
+		os.RemoveAll(archiveDir)
+	}
+}
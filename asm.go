@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// asmComments are the markers which start a comment in the assembly of the
+// target, set by setAsmTarget. '#' is an immediate on ARM and AArch64.
+var asmComments = []string{"#"}
+
+// targetTriple returns the triple the tests are compiled for: the -mtriple
+// of -llc-args, -run-target or the default target of the toolchain's llc.
+func targetTriple(tc *Toolchain) string {
+	args := append(append([]string(nil), llcArgs...), strings.Fields(*llcFlags)...)
+	for i, arg := range args {
+		arg = strings.TrimLeft(arg, "-")
+		switch {
+		case strings.HasPrefix(arg, "mtriple="):
+			return arg[len("mtriple="):]
+		case arg == "mtriple" && i+1 < len(args):
+			return args[i+1]
+		}
+	}
+	if *runTarget != "" {
+		return *runTarget
+	}
+	return tc.DefaultTarget
+}
+
+// setAsmTarget selects the comment markers of the assembly for the triple.
+func setAsmTarget(triple string) {
+	arch := strings.SplitN(triple, "-", 2)[0]
+	switch {
+	case strings.HasPrefix(arch, "aarch64") || strings.HasPrefix(arch, "arm64"):
+		asmComments = []string{"//", ";"}
+	case strings.HasPrefix(arch, "arm") || strings.HasPrefix(arch, "thumb"):
+		asmComments = []string{"@"}
+	case strings.HasPrefix(arch, "nvptx") || arch == "hexagon":
+		asmComments = []string{"//"}
+	case arch == "amdgcn" || arch == "r600" || arch == "msp430" || arch == "avr":
+		asmComments = []string{";"}
+	case strings.HasPrefix(arch, "sparc"):
+		asmComments = []string{"!"}
+	default:
+		asmComments = []string{"#"}
+	}
+}
+
+// stripComment drops the comment of a line of assembly.
+func stripComment(line string) string {
+	for _, marker := range asmComments {
+		if i := strings.Index(line, marker); i >= 0 {
+			line = line[:i]
+		}
+	}
+	return line
+}
+
+type asmLine struct {
+	label  string
+	instr  bool
+	fields []string
+}
+
+// classifyAsmLine splits a line of assembler output into a label, a
+// directive or an instruction. Comments and blank lines yield a zero asmLine.
+func classifyAsmLine(line string) (l asmLine) {
+	if line = strings.TrimSpace(stripComment(line)); line == "" {
+		return
+	}
+	if strings.HasSuffix(line, ":") {
+		l.label = line[:len(line)-1]
+		return
+	}
+	l.fields = strings.Fields(strings.Replace(line, ",", " ", -1))
+	l.instr = !strings.HasPrefix(line, ".")
+	return
+}
+
+// cfiMarkers are the .cfi_ directives which delimit or annotate the unwind
+// info of a function rather than describe a change of the frame.
+var cfiMarkers = map[string]bool{
+	".cfi_startproc": true, ".cfi_endproc": true, ".cfi_sections": true,
+	".cfi_personality": true, ".cfi_lsda": true,
+}
+
+// countCFIInstrs counts the CFI instructions of the assembly, the .cfi_
+// directives which end up in the unwind tables.
+func countCFIInstrs(asm string) (n int) {
+	for _, line := range strings.Split(asm, "\n") {
+		l := classifyAsmLine(line)
+		if len(l.fields) > 0 && strings.HasPrefix(l.fields[0], ".cfi_") && !cfiMarkers[l.fields[0]] {
+			n++
+		}
+	}
+	return
+}
+
+// tableLabelRegexp matches the labels of jump tables and constant pool
+// entries, e.g. .LJTI0_1 and .LCPI2_0, or LJTI0_1 and lCPI2_0 on Mach-O.
+var tableLabelRegexp = regexp.MustCompile(`^\.?[Ll](JTI|CPI)[0-9]+_[0-9]+$`)
+
+// dataSizes are the sizes of the values of the data directives in LLVM's
+// assembly, where .word is 4 bytes as on ARM; x86 uses .short instead.
+var dataSizes = map[string]int{
+	".byte": 1, ".short": 2, ".hword": 2, ".2byte": 2, ".value": 2, ".long": 4, ".int": 4, ".word": 4,
+	".4byte": 4, ".quad": 8, ".xword": 8, ".8byte": 8,
+}
+
+// countTables counts the jump tables and the constant pool entries of the
+// assembly and the bytes of data they hold.
+func countTables(asm string, stats *Stats) {
+	stats.JumpTables, stats.JumpTableBytes, stats.ConstPoolEntries, stats.ConstPoolBytes = 0, 0, 0, 0
+	var size *int
+	for _, line := range strings.Split(asm, "\n") {
+		l := classifyAsmLine(line)
+		switch {
+		case l.label != "":
+			size = nil
+			switch ss := tableLabelRegexp.FindStringSubmatch(l.label); {
+			case ss == nil:
+			case ss[1] == "JTI":
+				stats.JumpTables++
+				size = &stats.JumpTableBytes
+			default:
+				stats.ConstPoolEntries++
+				size = &stats.ConstPoolBytes
+			}
+		case len(l.fields) == 0 || size == nil:
+		case l.instr || l.fields[0] == ".section" || l.fields[0] == ".text" || l.fields[0] == ".data":
+			size = nil
+		case l.fields[0] == ".zero" || l.fields[0] == ".space":
+			if len(l.fields) > 1 {
+				n, _ := strconv.Atoi(l.fields[1])
+				*size += n
+			}
+		default:
+			*size += dataSizes[l.fields[0]] * (len(l.fields) - 1)
+		}
+	}
+}
+
+var volatileDirectives = []string{".file", ".ident", ".section\t.note.GNU-stack"}
+
+// normalizeAsm drops comments, blank lines, indentation and directives which
+// differ between toolchains without affecting the generated code.
+func normalizeAsm(asm string) string {
+	var res []string
+	for _, line := range strings.Split(asm, "\n") {
+		if line = strings.TrimSpace(stripComment(line)); line == "" {
+			continue
+		}
+		volatile := false
+		for _, d := range volatileDirectives {
+			if strings.HasPrefix(line, d) {
+				volatile = true
+				break
+			}
+		}
+		if !volatile {
+			res = append(res, line)
+		}
+	}
+	return strings.Join(res, "\n")
+}
+
+func asmHash(asm string) string {
+	h := sha1.New()
+	h.Write([]byte(normalizeAsm(asm)))
+	return fmt.Sprintf("%x", h.Sum())
+}
+
+// countFunctionInstrs counts the instructions of each function in the
+// assembly.
+func countFunctionInstrs(asm string) (counts map[string]int) {
+	counts = make(map[string]int)
+	for name, instrs := range functionInstrs(asm) {
+		counts[name] = len(instrs)
+	}
+	return
+}
+
+// functionInstrs returns the instructions of each function in the assembly,
+// with their operands separated by single spaces. Functions are the labels
+// declared with .type name,@function; if there are none (e.g. Mach-O), every
+// label not starting with 'L' or '.' is taken to start a function.
+func functionInstrs(asm string) (instrs map[string][]string) {
+	lines := strings.Split(asm, "\n")
+	funcs := make(map[string]bool)
+	for _, line := range lines {
+		l := classifyAsmLine(line)
+		if len(l.fields) >= 3 && l.fields[0] == ".type" &&
+			(l.fields[2] == "@function" || l.fields[2] == "%function") {
+			funcs[l.fields[1]] = true
+		}
+	}
+	instrs = make(map[string][]string)
+	cur := ""
+	for _, line := range lines {
+		l := classifyAsmLine(line)
+		switch {
+		case l.label != "":
+			if funcs[l.label] || (len(funcs) == 0 && l.label[0] != 'L' && l.label[0] != '.') {
+				cur = l.label
+				if _, ok := instrs[cur]; !ok {
+					instrs[cur] = []string{}
+				}
+			}
+		case l.instr && cur != "":
+			instrs[cur] = append(instrs[cur], strings.Join(l.fields, " "))
+		case len(l.fields) >= 2 && l.fields[0] == ".size" && l.fields[1] == cur:
+			cur = ""
+		}
+	}
+	return
+}
+
+type funcDelta struct {
+	Name   string
+	Counts [2]int
+}
+
+func (d funcDelta) delta() int {
+	return d.Counts[1] - d.Counts[0]
+}
+
+type byAbsDelta []funcDelta
+
+func (s byAbsDelta) Len() int      { return len(s) }
+func (s byAbsDelta) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byAbsDelta) Less(i, j int) bool {
+	di, dj := s[i].delta(), s[j].delta()
+	if di < 0 {
+		di = -di
+	}
+	if dj < 0 {
+		dj = -dj
+	}
+	if di != dj {
+		return di > dj
+	}
+	return s[i].Name < s[j].Name
+}
+
+// functionDeltas returns at most n functions whose instruction counts differ
+// the most between the two toolchains. Functions missing on one side count
+// as zero instructions there.
+func functionDeltas(stats [2]*Stats, n int) []funcDelta {
+	return countDeltas([2]map[string]int{stats[0].Functions, stats[1].Functions}, n)
+}
+
+// countDeltas returns at most n names, or all with a negative n, whose
+// counts differ the most between the two maps, a missing name counting as
+// zero.
+func countDeltas(counts [2]map[string]int, n int) []funcDelta {
+	all := make(map[string]*funcDelta)
+	for i, m := range counts {
+		for name, count := range m {
+			d, ok := all[name]
+			if !ok {
+				d = &funcDelta{Name: name}
+				all[name] = d
+			}
+			d.Counts[i] = count
+		}
+	}
+	var res []funcDelta
+	for _, d := range all {
+		if d.delta() != 0 {
+			res = append(res, *d)
+		}
+	}
+	sort.Sort(byAbsDelta(res))
+	if n >= 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+type funcDiff struct {
+	Name string
+	// Changed is the number of instructions removed or added.
+	Changed int
+	Counts  [2]int
+}
+
+type byChanged []funcDiff
+
+func (s byChanged) Len() int      { return len(s) }
+func (s byChanged) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byChanged) Less(i, j int) bool {
+	if s[i].Changed != s[j].Changed {
+		return s[i].Changed > s[j].Changed
+	}
+	return s[i].Name < s[j].Name
+}
+
+// diffFunctions diffs the instructions of the functions with the same name in
+// both assemblies and returns the functions which differ, the most changed
+// first. A function missing on one side has all its instructions changed.
+func diffFunctions(asm [2]string) (diffs []funcDiff) {
+	funcs := [2]map[string][]string{functionInstrs(asm[0]), functionInstrs(asm[1])}
+	seen := make(map[string]bool)
+	for _, fs := range funcs {
+		for name := range fs {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			d := funcDiff{Name: name, Counts: [2]int{len(funcs[0][name]), len(funcs[1][name])}}
+			for _, op := range diffMasked(funcs[0][name], funcs[1][name]) {
+				if op.Kind != ' ' {
+					d.Changed++
+				}
+			}
+			if d.Changed > 0 {
+				diffs = append(diffs, d)
+			}
+		}
+	}
+	sort.Sort(byChanged(diffs))
+	return
+}
+
+var instrCategories = []string{"branch", "vector", "memory", "move", "other"}
+
+var vectorOperandRegexp = regexp.MustCompile(`%[xyz]mm[0-9]|\bv[0-9]+\.|\b[qd][0-9]+\b`)
+
+// classifyInstr puts an instruction into one of instrCategories. The
+// categories are checked in order, so e.g. a vector load is a vector op and a
+// move from memory is a memory op.
+func classifyInstr(fields []string) string {
+	op := fields[0]
+	operands := strings.Join(fields[1:], " ")
+	switch {
+	case op[0] == 'j' || strings.HasPrefix(op, "call") || strings.HasPrefix(op, "ret") ||
+		op == "b" || strings.HasPrefix(op, "b.") || op == "bl" || op == "br" || op == "blr" || op == "bx" ||
+		op == "blx" || strings.HasPrefix(op, "cb") || strings.HasPrefix(op, "tb"):
+		return "branch"
+	case op[0] == 'v' || vectorOperandRegexp.MatchString(operands):
+		return "vector"
+	case strings.Contains(operands, "(") || strings.Contains(operands, "[") || strings.HasPrefix(op, "push") ||
+		strings.HasPrefix(op, "pop") || strings.HasPrefix(op, "ld") || strings.HasPrefix(op, "st"):
+		return "memory"
+	case strings.HasPrefix(op, "mov") || strings.HasPrefix(op, "cmov"):
+		return "move"
+	}
+	return "other"
+}
+
+// countInstrCategories counts the instructions of the assembly by category.
+func countInstrCategories(asm string) (counts map[string]int) {
+	counts = make(map[string]int)
+	for _, line := range strings.Split(asm, "\n") {
+		if l := classifyAsmLine(line); l.instr && len(l.fields) > 0 {
+			counts[classifyInstr(l.fields)]++
+		}
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// installPrefixGlobs are where LLVM is usually installed: by the Debian and
+// Ubuntu packages, by Homebrew on Apple silicon and on Intel Macs, and by
+// hand.
+var installPrefixGlobs = []string{
+	"/usr/lib/llvm-*",
+	"/usr/lib/llvm",
+	"/opt/homebrew/opt/llvm*",
+	"/usr/local/opt/llvm*",
+	"/opt/llvm*",
+	"/usr/local/llvm*",
+}
+
+// installedToolchain is an LLVM installation with the -t1/-t2 spec which
+// selects it.
+type installedToolchain struct {
+	spec    string
+	llc     string
+	version string
+}
+
+// candidateSpecs lists the toolchain specs worth checking: llc on PATH, the
+// versioned llc-<N> binaries in the PATH directories and the usual install
+// prefixes.
+func candidateSpecs() (specs []string) {
+	specs = append(specs, "PATH")
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, _ := filepath.Glob(filepath.Join(dir, exeName("llc-[0-9]*")))
+		for _, f := range files {
+			base := filepath.Base(f)
+			if onWindows {
+				base = base[:len(base)-len(".exe")]
+			}
+			specs = append(specs, fmt.Sprintf("path=%s,suffix=%s", filepath.Dir(dir), base[len("llc"):]))
+		}
+	}
+	for _, pattern := range installPrefixGlobs {
+		prefixes, _ := filepath.Glob(pattern)
+		specs = append(specs, prefixes...)
+	}
+	return
+}
+
+// detectToolchains returns the installations whose llc runs, each once even
+// if several specs reach it.
+func detectToolchains() (found []*installedToolchain) {
+	seen := make(map[string]bool)
+	for _, spec := range candidateSpecs() {
+		tc, err := parseToolchain("", spec)
+		if err != nil {
+			continue
+		}
+		llc := tc.tool("llc")
+		real, err := filepath.EvalSymlinks(llc)
+		if err != nil || seen[real] {
+			continue
+		}
+		seen[real] = true
+		out, err := tc.run(nil, "llc", "-version")
+		if err != nil {
+			continue
+		}
+		version := "unknown"
+		if ss := llvmVersionRegexp.FindStringSubmatch(string(out)); ss != nil {
+			version = ss[1]
+		}
+		found = append(found, &installedToolchain{spec, llc, version})
+	}
+	return
+}
+
+// toolchainsCommand lists the LLVM installations on the machine with the
+// specs to pass as -t1 and -t2.
+func toolchainsCommand(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	found := detectToolchains()
+	if len(found) == 0 {
+		fmt.Printf("No LLVM installation found on PATH or in %s\n", strings.Join(installPrefixGlobs, " "))
+		return
+	}
+	fmt.Printf("version\tllc\tspec\n")
+	for _, it := range found {
+		fmt.Printf("%s\t%s\t%s\n", it.version, it.llc, it.spec)
+	}
+}
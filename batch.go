@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// measure runs the test -repeat times with both toolchains and keeps the
+// fastest timings. Only the first repetition does the deep analyses.
+func measure(tcs [2]*Toolchain, test string) (stats [2]*Stats, err os.Error) {
+	for i := 0; i < *repeat; i++ {
+		mode := deepRun
+		if i > 0 {
+			mode = warmupRun
+		}
+		var cur [2]*Stats
+		if cur, err = runBoth(tcs, test, mode); err != nil {
+			return
+		}
+		if i == 0 {
+			stats = cur
+			continue
+		}
+		for j, s := range stats {
+			keepFastest(s, cur[j])
+		}
+	}
+	return
+}
+
+func keepFastest(s, other *Stats) {
+	if other.Seconds < s.Seconds {
+		s.Seconds = other.Seconds
+	}
+	if other.WallSeconds < s.WallSeconds {
+		s.WallSeconds = other.WallSeconds
+	}
+	if other.ProcessWallSeconds < s.ProcessWallSeconds {
+		s.ProcessWallSeconds = other.ProcessWallSeconds
+	}
+	for pass, t := range other.PassTimes {
+		if prev, ok := s.PassTimes[pass]; ok && t < prev {
+			s.PassTimes[pass] = t
+		}
+	}
+}
+
+// sameOutput reports whether both toolchains produced the same assembly and,
+// with -disasm=also, the same disassembly.
+func sameOutput(stats [2]*Stats) bool {
+	return stats[0].AsmHash == stats[1].AsmHash && stats[0].DisasmHash == stats[1].DisasmHash
+}
+
+// runOne warms up and measures a single test, or takes its result from the
+// cache.
+func runOne(tcs [2]*Toolchain, test string) *Result {
+	if res := preTestHook(test); res != nil {
+		return res
+	}
+	if res := cache.lookup(test, deepRun); res != nil {
+		return cachedResult(tcs, res)
+	}
+	if !*onlyRegressions {
+		fmt.Printf("Running test: %s\n", test)
+	}
+	if *verify {
+		if res := verifyInputs(tcs, test); res != nil {
+			return res
+		}
+	}
+	stats, err := runBoth(tcs, test, warmupRun)
+	if err != nil {
+		return failedResult(test, "runBoth", err)
+	}
+	identical := sameOutput(stats)
+	if !identical || !*skipIdentical {
+		if stats, err = measure(tcs, test); err != nil {
+			return failedResult(test, "runBoth(2)", err)
+		}
+	}
+	res := newResult(tcs, test, stats, identical)
+	if res.Err == nil {
+		cache.store(res, deepRun)
+	}
+	return res
+}
+
+func runTests(tcs [2]*Toolchain, tests []string, rep *report) {
+	forEachTest(tests, func(test string) *Result {
+		return runOne(tcs, test)
+	}, rep.watch, func(i int, res *Result) {
+		rep.add(res)
+	})
+}
+
+// runTwoPhase first runs every test once without timing to find the tests
+// whose codegen or metrics differ, then measures only those.
+func runTwoPhase(tcs [2]*Toolchain, tests []string, rep *report) {
+	results := make([]*Result, len(tests))
+	forEachTest(tests, func(test string) *Result {
+		if res := preTestHook(test); res != nil {
+			return res
+		}
+		// A measured result is as good as a scanned one.
+		if res := cache.lookup(test, deepRun); res != nil {
+			return cachedResult(tcs, res)
+		}
+		if res := cache.lookup(test, quickRun); res != nil {
+			addGolden(res)
+			return res
+		}
+		if !*onlyRegressions {
+			fmt.Printf("Scanning test: %s\n", test)
+		}
+		if *verify {
+			if res := verifyInputs(tcs, test); res != nil {
+				return res
+			}
+		}
+		stats, err := runBoth(tcs, test, quickRun)
+		if err != nil {
+			return failedResult(test, "runBoth", err)
+		}
+		res := &Result{Test: test, Stats: stats, Identical: sameOutput(stats)}
+		addGolden(res)
+		return res
+	}, rep.watch, func(i int, res *Result) {
+		results[i] = res
+	})
+
+	var differing []string
+	var indices []int
+	measured := make([]bool, len(tests))
+	for i, res := range results {
+		if res != nil && !res.cached && res.Err == nil && (!res.Identical || res.diverged()) {
+			differing = append(differing, res.Test)
+			indices = append(indices, i)
+		}
+	}
+	if !*onlyRegressions {
+		fmt.Printf("%d of %d tests differ\n", len(differing), len(tests))
+	}
+	forEachTest(differing, func(test string) *Result {
+		if !*onlyRegressions {
+			fmt.Printf("Running test: %s\n", test)
+		}
+		stats, err := measure(tcs, test)
+		if err != nil {
+			return failedResult(test, "runBoth(2)", err)
+		}
+		return newResult(tcs, test, stats, false)
+	}, rep.watch, func(i int, res *Result) {
+		results[indices[i]] = res
+		measured[indices[i]] = true
+	})
+
+	for i, res := range results {
+		if res == nil {
+			// The batch was stopped before the test ran.
+			continue
+		}
+		if !res.cached && res.Err == nil {
+			// The scanned results have no timings.
+			mode := quickRun
+			if measured[i] {
+				mode = deepRun
+			}
+			cache.store(res, mode)
+		}
+		rep.add(res)
+	}
+}
+
+func failedResult(test, what string, err os.Error) *Result {
+	res := &Result{Test: test, Err: fmt.Errorf("%s: %v", what, err), Statuses: [2]string{statusFail, statusFail}}
+	re, ok := err.(*runError)
+	if !ok {
+		info := newErrorInfo("run", err)
+		res.Errors = []*ErrorInfo{info, info}
+		return res
+	}
+	keepFailureLogs(test, re.errs)
+	res.Errors = make([]*ErrorInfo, 2)
+	for i, e := range re.errs {
+		res.Statuses[i] = statusOf(e)
+		if e != nil {
+			res.Errors[i] = newErrorInfo("run", e)
+		}
+		if res.Crash == nil {
+			res.Crash = crashOf(e)
+		}
+	}
+	return res
+}
+
+func newResult(tcs [2]*Toolchain, test string, stats [2]*Stats, identical bool) *Result {
+	res := &Result{Test: test, Stats: stats, Identical: identical}
+	addGolden(res)
+	if *functionDiff && !identical {
+		res.FunctionDiffs = diffFunctions([2]string{stats[0].Asm, stats[1].Asm})
+	}
+	if *artifactDir != "" && !identical {
+		if err := writeAsmArtifacts(tcs, res); err != nil {
+			log.Printf("writeAsmArtifacts(%s): %v", test, err)
+		}
+	}
+	keepLogs(tcs, res)
+	return postCompareHook(res)
+}
+
+// cachedResult post-processes a result from the cache like a fresh one: its
+// snapshots, artifacts and logs, and the -post-compare-hook.
+func cachedResult(tcs [2]*Toolchain, cached *Result) *Result {
+	res := newResult(tcs, cached.Test, cached.Stats, cached.Identical)
+	res.cached = true
+	return res
+}
+
+func addGolden(res *Result) {
+	if *goldenDir == "" {
+		return
+	}
+	if err := checkGolden(res); err != nil {
+		log.Printf("checkGolden(%s): %v", res.Test, err)
+	}
+}
+
+// artifactName names the files of the test by its base name and a short hash
+// of its path, so that tests with the same base name in different
+// directories do not overwrite each other's files.
+func artifactName(test string) string {
+	h := sha1.New()
+	io.WriteString(h, test)
+	return fmt.Sprintf("%s.%x", path.Base(test), h.Sum()[:4])
+}
+
+func artifactPath(test, suffix string) string {
+	return path.Join(*artifactDir, artifactName(test)+suffix)
+}
+
+// writeAsmArtifacts saves both assemblies of the test and their diff.
+func writeAsmArtifacts(tcs [2]*Toolchain, res *Result) (err os.Error) {
+	if err = os.MkdirAll(*artifactDir, 0755); err != nil {
+		return
+	}
+	var names [2]string
+	for i, tc := range tcs {
+		names[i] = artifactPath(res.Test, "."+tc.Name+".s")
+		if err = ioutil.WriteFile(names[i], []byte(res.Stats[i].Asm), 0644); err != nil {
+			return
+		}
+	}
+	ops := diffAsm(strings.Split(res.Stats[0].Asm, "\n"), strings.Split(res.Stats[1].Asm, "\n"))
+	res.DiffFile = artifactPath(res.Test, ".diff")
+	if err = ioutil.WriteFile(res.DiffFile, []byte(unifiedDiff(names[0], names[1], ops, 3)), 0644); err != nil {
+		return
+	}
+	res.SideBySideFile = artifactPath(res.Test, ".html")
+	return writeSideBySide(res.SideBySideFile, tcs, res.Test, ops)
+}
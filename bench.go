@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildExecutable assembles the assembly with llvm-mc and links the object
+// into an executable with the toolchain's clang, so the test must define main.
+// With -run-target, it is linked for that target. The extra arguments go to
+// clang after the object.
+func buildExecutable(tc *Toolchain, asm string, extra ...string) (exeFile string, err os.Error) {
+	var objFile string
+	if objFile, err = assemble(tc, asm); err != nil {
+		return
+	}
+	defer os.Remove(objFile)
+	exeFile = objFile + ".exe"
+	args := append(append(linkArgs(), "-o", exeFile, objFile), extra...)
+	if _, err = runTimed(tc.tool("clang"), args, nil); err != nil {
+		os.Remove(exeFile)
+		return "", fmt.Errorf("clang: %v", err)
+	}
+	return
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// benchmark runs the executable built from the assembly -run-warmup plus
+// -run-iterations times, as the -run-manifest tells for the test, and records
+// the wall time of each counted iteration, their median and minimum, and the
+// median CPU time.
+func benchmark(tc *Toolchain, test, asm string, stats *Stats) (err os.Error) {
+	if *runIterations < 1 {
+		return fmt.Errorf("-run-iterations must be at least 1, got %d", *runIterations)
+	}
+	var exeFile string
+	if exeFile, err = buildExecutable(tc, asm); err != nil {
+		return
+	}
+	defer os.Remove(exeFile)
+	localExe := exeFile
+	if dev != nil {
+		if exeFile, err = dev.push(exeFile); err != nil {
+			return
+		}
+		defer dev.remove(exeFile)
+	}
+	spec := runSpecFor(test)
+	var cpu []float64
+	stats.RunTimes, stats.RunOutput = nil, nil
+	for i := 0; i < *runWarmup+*runIterations; i++ {
+		var out *testOutput
+		if out, err = runExecutable(exeFile, spec); err != nil {
+			if lf, ok := err.(*llcFailure); ok && !lf.timedOut {
+				return captureCrash(tc, localExe, spec, i, lf)
+			}
+			return fmt.Errorf("iteration %d: %v", i, err)
+		}
+		if i < *runWarmup {
+			continue
+		}
+		if *checkOutput && stats.RunOutput == nil {
+			stats.RunOutput = &out.stdout
+		}
+		stats.RunTimes = append(stats.RunTimes, out.wallSeconds)
+		cpu = append(cpu, out.cpuSeconds)
+	}
+	stats.RunSeconds = median(stats.RunTimes)
+	stats.RunMinSeconds = stats.RunTimes[0]
+	for _, t := range stats.RunTimes {
+		if t < stats.RunMinSeconds {
+			stats.RunMinSeconds = t
+		}
+	}
+	stats.RunCPUSeconds = median(cpu)
+	if *startup {
+		if stats.StartupSeconds, err = measureStartup(exeFile, spec); err != nil {
+			return fmt.Errorf("measureStartup: %v", err)
+		}
+	}
+	if *perfCounters {
+		if err = perfStat(exeFile, spec, stats); err != nil {
+			return fmt.Errorf("perfStat: %v", err)
+		}
+	}
+	if *runStack {
+		if err = measureStack(tc, asm, spec, stats); err != nil {
+			return fmt.Errorf("measureStack: %v", err)
+		}
+	}
+	if *runHeap {
+		if err = measureHeap(tc, asm, spec, stats); err != nil {
+			return fmt.Errorf("measureHeap: %v", err)
+		}
+	}
+	return
+}
+
+// perfEvents maps the events perf stat counts to the Stats fields.
+var perfEvents = []struct {
+	event string
+	field func(s *Stats) *float64
+}{
+	{"cycles", func(s *Stats) *float64 { return &s.Cycles }},
+	{"instructions", func(s *Stats) *float64 { return &s.Instructions }},
+	{"branch-misses", func(s *Stats) *float64 { return &s.BranchMisses }},
+	{"L1-dcache-load-misses", func(s *Stats) *float64 { return &s.L1DMisses }},
+}
+
+// perfStat counts the perfEvents of the executable with perf stat, averaged
+// over -run-iterations runs. The events the CPU does not count stay 0.
+func perfStat(exeFile string, spec *runSpec, stats *Stats) (err os.Error) {
+	var events []string
+	for _, e := range perfEvents {
+		events = append(events, e.event)
+	}
+	command, cmdArgs := spec.command(exeFile)
+	if spec.Stdin != "" {
+		// perf stat -r repeats the run, so every run opens the stdin file
+		// anew.
+		cmdArgs = append([]string{"-c", `exec "$@" < "$0"`, spec.Stdin, command}, cmdArgs...)
+		command = "sh"
+	}
+	args := []string{"stat", "-x,", "-r", strconv.Itoa(*runIterations), "-e", strings.Join(events, ","), "--", command}
+	args = append(args, cmdArgs...)
+	var out *testOutput
+	if out, err = runTimed("perf", args, nil); err != nil {
+		return
+	}
+	// The CSV lines are value,unit,event,...; the event may have a
+	// modifier such as :u.
+	for _, line := range strings.Split(out.stderr, "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		event := strings.SplitN(fields[2], ":", 2)[0]
+		v, err := strconv.Atof64(fields[0])
+		if err != nil {
+			continue
+		}
+		for _, e := range perfEvents {
+			if e.event == event {
+				*e.field(stats) = v
+			}
+		}
+	}
+	return
+}
+
+// firstOutput runs the executable and returns the time from starting it to
+// its first byte of output, or to its exit if it prints nothing.
+func firstOutput(exeFile string, spec *runSpec) (seconds float64, err os.Error) {
+	cmd, release := pinnedCommand(spec.command(exeFile))
+	defer release()
+	cmd.Stdin = bytes.NewBuffer(spec.stdin)
+	// Both streams go to one pipe, so that the first byte of either is seen.
+	var r, w *os.File
+	if r, w, err = os.Pipe(); err != nil {
+		return
+	}
+	defer r.Close()
+	cmd.Stdout, cmd.Stderr = w, w
+	start := time.Nanoseconds()
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, fmt.Errorf("cmd.Start: %v", err)
+	}
+	if *timeout > 0 {
+		timer := time.AfterFunc(int64(*timeout)*1e9, func() { cmd.Process.Kill() })
+		defer timer.Stop()
+	}
+	buf := make([]byte, 1)
+	var end int64
+	if n, _ := r.Read(buf); n > 0 {
+		end = time.Nanoseconds()
+		io.Copy(ioutil.Discard, r)
+	}
+	if err = cmd.Wait(); err != nil {
+		return 0, err
+	}
+	if end == 0 {
+		end = time.Nanoseconds()
+	}
+	return float64(end-start) / 1e9, nil
+}
+
+// measureStartup returns the median time to the first output over the
+// -run-iterations runs which follow the -run-warmup ones.
+func measureStartup(exeFile string, spec *runSpec) (seconds float64, err os.Error) {
+	var times []float64
+	for i := 0; i < *runWarmup+*runIterations; i++ {
+		var t float64
+		if t, err = firstOutput(exeFile, spec); err != nil {
+			return 0, fmt.Errorf("iteration %d: %v", i, err)
+		}
+		if i >= *runWarmup {
+			times = append(times, t)
+		}
+	}
+	return median(times), nil
+}
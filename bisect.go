@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// bisectCommand finds the first build in an ordered list of toolchains with
+// which any of the tests fails or regresses beyond the thresholds compared
+// with the first build. The builds are assumed to go bad once and stay bad.
+func bisectCommand(fs *flag.FlagSet, args []string) {
+	buildsFile := fs.String("builds", "", "File with one toolchain per line, oldest first; "+
+		"the first one is the known good baseline")
+	fs.Parse(args)
+	checkArg("-builds", *buildsFile != "")
+	tests := collectTests(fs.Args())
+	data, err := ioutil.ReadFile(*buildsFile)
+	if err != nil {
+		log.Fatalf("ioutil.ReadFile: %v", err)
+	}
+	var builds []*Toolchain
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		tc, err := parseToolchain(fmt.Sprintf("b%d", len(builds)), line)
+		if err != nil {
+			log.Fatalf("parseToolchain: %v", err)
+		}
+		builds = append(builds, tc)
+	}
+	if len(builds) < 2 {
+		log.Fatalf("%s must list at least two toolchains", *buildsFile)
+	}
+	limits, err := parseThresholds(*thresholds)
+	if err != nil {
+		log.Fatalf("parseThresholds: %v", err)
+	}
+
+	// The commit:<hash> builds are only fetched once the bisection reaches them.
+	bad := func(i int) bool {
+		tcs := [2]*Toolchain{builds[0], builds[i]}
+		for _, tc := range tcs {
+			if err := tc.fetch(); err != nil {
+				log.Fatalf("fetch: %v", err)
+			}
+		}
+		for _, test := range tests {
+			res := runOne(tcs, test)
+			if res.Err != nil || res.severity(limits) > 0 {
+				fmt.Printf("%s is bad: %s regresses\n", builds[i], test)
+				return true
+			}
+		}
+		fmt.Printf("%s is good\n", builds[i])
+		return false
+	}
+	good, last := 0, len(builds)-1
+	if !bad(last) {
+		fmt.Printf("The tests do not regress with the last build, %s\n", builds[last])
+		return
+	}
+	for last-good > 1 {
+		if mid := (good + last) / 2; bad(mid) {
+			last = mid
+		} else {
+			good = mid
+		}
+	}
+	cleanupInputs()
+	fmt.Printf("The first bad build is %s (%s); the last good one is %s (%s)\n",
+		builds[last], builds[last].Path, builds[good], builds[good].Path)
+}
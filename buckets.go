@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type sizeBucket struct {
+	label  string
+	min    int64 // inclusive
+	max    int64 // exclusive, 0 for unbounded
+	tests  int
+	totals [2][]float64
+}
+
+var sizeSuffixes = map[byte]int64{'k': 1 << 10, 'K': 1 << 10, 'm': 1 << 20, 'M': 1 << 20, 'g': 1 << 30, 'G': 1 << 30}
+
+func parseSize(s string) (n int64, err os.Error) {
+	mult := int64(1)
+	if len(s) > 0 {
+		if m, ok := sizeSuffixes[s[len(s)-1]]; ok {
+			mult, s = m, s[:len(s)-1]
+		}
+	}
+	if n, err = strconv.Atoi64(s); err != nil {
+		return
+	}
+	return n * mult, nil
+}
+
+// parseBuckets turns a list of increasing bin edges such as "100k,1M" into
+// the buckets below, between and above them.
+func parseBuckets(spec string, nmetrics int) (buckets []*sizeBucket, err os.Error) {
+	edges := strings.Split(spec, ",")
+	prev, prevLabel := int64(0), ""
+	for _, edge := range edges {
+		edge = strings.TrimSpace(edge)
+		var n int64
+		if n, err = parseSize(edge); err != nil {
+			return nil, fmt.Errorf("bad bucket edge %q: %v", edge, err)
+		}
+		if n <= prev {
+			return nil, fmt.Errorf("bucket edges %q are not increasing", spec)
+		}
+		label := "<" + edge
+		if prevLabel != "" {
+			label = prevLabel + "-" + edge
+		}
+		buckets = append(buckets, &sizeBucket{label: label, min: prev, max: n})
+		prev, prevLabel = n, edge
+	}
+	buckets = append(buckets, &sizeBucket{label: ">=" + prevLabel, min: prev})
+	for _, b := range buckets {
+		for i := range b.totals {
+			b.totals[i] = make([]float64, nmetrics)
+		}
+	}
+	return
+}
+
+func bucketResults(spec string, ms []metric, results []*Result) (buckets []*sizeBucket, err os.Error) {
+	if buckets, err = parseBuckets(spec, len(ms)); err != nil {
+		return
+	}
+	for _, res := range results {
+		for _, b := range buckets {
+			if res.InputBytes < b.min || (b.max > 0 && res.InputBytes >= b.max) {
+				continue
+			}
+			b.tests++
+			for i, s := range res.Stats {
+				for j, m := range ms {
+					b.totals[i][j] += m.value(s)
+				}
+			}
+			break
+		}
+	}
+	return
+}
+
+func printBuckets(ms []metric, buckets []*sizeBucket) {
+	fmt.Printf("\nsize\ttests")
+	for _, m := range ms {
+		fmt.Printf("\t%s.delta_pct", m.name)
+	}
+	fmt.Println()
+	for _, b := range buckets {
+		fmt.Printf("%s\t%d", b.label, b.tests)
+		for j := range ms {
+			fmt.Printf("\t%.2f", deltaPct(b.totals[0][j], b.totals[1][j]))
+		}
+		fmt.Println()
+	}
+}
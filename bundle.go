@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io"
+	"io/ioutil"
+	"json"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// bundleLog keeps a copy of the log for the -bundle archive.
+var bundleLog bytes.Buffer
+
+func captureLog() {
+	log.SetOutput(io.MultiWriter(os.Stderr, &bundleLog))
+}
+
+// bundleMeta is the run.json of a bundle.
+type bundleMeta struct {
+	Run   *Run              `json:"run"`
+	Args  []string          `json:"args"`
+	Flags map[string]string `json:"flags"`
+}
+
+type bundleWriter struct {
+	tw    *tar.Writer
+	mtime int64
+}
+
+func (b *bundleWriter) add(name string, data []byte) (err os.Error) {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Mtime: b.mtime, Typeflag: tar.TypeReg}
+	if err = b.tw.WriteHeader(hdr); err != nil {
+		return
+	}
+	_, err = b.tw.Write(data)
+	return
+}
+
+// addFile adds a file under dir in the bundle, unless it does not exist.
+func (b *bundleWriter) addFile(dir, filename string) (err os.Error) {
+	if _, err = os.Stat(filename); err != nil {
+		return nil
+	}
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	return b.add(path.Join(dir, path.Base(filename)), data)
+}
+
+// addDir adds the files of a directory, which is not searched recursively,
+// unless it does not exist.
+func (b *bundleWriter) addDir(dir string) (err os.Error) {
+	if _, err = os.Stat(dir); err != nil {
+		return nil
+	}
+	var fis []*os.FileInfo
+	if fis, err = ioutil.ReadDir(dir); err != nil {
+		return
+	}
+	for _, fi := range fis {
+		if fi.IsRegular() {
+			if err = b.addFile(path.Base(dir), path.Join(dir, fi.Name)); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// writeBundle packs the run metadata, the results, the reports, the
+// artifacts and reproducers of the tests and the log into a .tar.gz file.
+func writeBundle(filename string, run *Run, results []*Result) (err os.Error) {
+	var f *os.File
+	if f, err = os.Create(filename); err != nil {
+		return
+	}
+	defer f.Close()
+	var gz *gzip.Compressor
+	if gz, err = gzip.NewWriter(f); err != nil {
+		return
+	}
+	b := &bundleWriter{tar.NewWriter(gz), time.Seconds()}
+
+	meta := &bundleMeta{Run: run, Args: os.Args, Flags: make(map[string]string)}
+	flag.VisitAll(func(f *flag.Flag) {
+		meta.Flags[f.Name] = f.Value.String()
+	})
+	var data []byte
+	if data, err = json.MarshalIndent(meta, "", "  "); err != nil {
+		return
+	}
+	if err = b.add("run.json", data); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = writeJSONResults(&buf, run, results); err != nil {
+		return
+	}
+	if err = b.add("results.json", buf.Bytes()); err != nil {
+		return
+	}
+	for _, report := range []string{*htmlOut, *templateOut, *resultsOut} {
+		if report != "" {
+			if err = b.addFile("reports", report); err != nil {
+				return
+			}
+		}
+	}
+	for _, dir := range []string{*artifactDir, *reduceDir} {
+		if dir != "" {
+			if err = b.addDir(dir); err != nil {
+				return
+			}
+		}
+	}
+	if err = b.add("log.txt", bundleLog.Bytes()); err != nil {
+		return
+	}
+	if err = b.tw.Close(); err != nil {
+		return
+	}
+	return gz.Close()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"json"
+	"log"
+	"os"
+	"sync"
+)
+
+type cacheEntry struct {
+	Stats         [2]*Stats
+	Identical     bool
+	FunctionDiffs []funcDiff
+	// The assembly and the compiler logs are kept for the post-processing
+	// of the cached result if the run which stored it had them, as told by
+	// KeptAsm and KeptLogs.
+	Asm               [2]string
+	Stdout, Stderr    [2]string
+	KeptAsm, KeptLogs bool
+}
+
+// keepsLogs reports whether the compiler logs of the tests are saved.
+func keepsLogs() bool {
+	return *artifactDir != "" && *keepLogsFlag != "none"
+}
+
+// resultCache remembers results keyed by the contents of the test, the
+// contents of the tools of both toolchains, the run mode and the flags which
+// affect the results.
+type resultCache struct {
+	filename   string
+	toolHashes [2]string
+	mu         sync.Mutex
+	Entries    map[string]*cacheEntry
+	// MaxRSS is the largest peak RSS seen for each test, in kilobytes.
+	MaxRSS map[string]int64
+	// Durations is the last wall time of each test with both toolchains.
+	Durations map[string]float64
+}
+
+// cache is nil unless -incremental is given.
+var cache *resultCache
+
+// uncachedFlags are the flags which do not change the results of a test, only
+// what is done with them or how the tests are scheduled, so the cache key
+// leaves them out. Every other flag is part of the key.
+var uncachedFlags = map[string]bool{
+	"allow-version-skew": true, "append": true, "artifact-dir": true, "bundle": true, "cas": true,
+	"categories": true, "checksums": true, "columns": true, "crash-only": true, "dedup": true,
+	"diagnostics": true, "difftool": true, "download-cache": true, "exit-on": true, "fail-fast": true,
+	"html": true, "incremental": true, "isolated-cpus": true, "j": true, "keep-logs": true, "label": true,
+	"log-limit": true, "max-failures": true, "max-mem": true, "max-version-skew": true, "notes": true,
+	"only-regressions": true, "out": true, "output-abs-epsilon": true, "output-rel-epsilon": true,
+	"output-tolerances": true, "reduce-dir": true, "require-performance-governor": true, "size-buckets": true,
+	"skip": true, "sort": true, "stage-cache": true, "t1": true, "t2": true, "template": true,
+	"template-out": true, "test": true, "thermal-interval": true, "thresholds": true, "toolchain-cache": true,
+	"toolchain-url": true, "tui": true, "two-phase": true, "unquarantine": true, "update-golden": true,
+	"xfail": true,
+}
+
+// cachedFiles are the flags naming files whose contents affect the results.
+var cachedFiles = []string{"counter-aliases", "pipeline", "run-manifest"}
+
+// cachedTools are the tools whose binaries affect the results, besides the
+// compiler of the toolchain.
+var cachedTools = []string{"llc", "llvm-mc", "opt", "llvm-as", "llvm-dis", "llvm-objdump", "clang"}
+
+// toolsHash hashes the binaries of the tools a run of the toolchain may
+// execute; a missing tool counts too, in case it is installed later.
+func toolsHash(tc *Toolchain) string {
+	h := sha1.New()
+	seen := make(map[string]bool)
+	for _, name := range append([]string{tc.driver().Compiler()}, cachedTools...) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		fh, err := fileHash(tc.tool(name))
+		if err != nil {
+			fh = "missing"
+		}
+		io.WriteString(h, name+"="+fh+"\n")
+	}
+	return fmt.Sprintf("%x", h.Sum())
+}
+
+// cacheOptions describes the run mode and the flags which affect the
+// results, with the contents of the files they name.
+func cacheOptions(mode runMode) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "mode=%+v\n", mode)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !uncachedFlags[f.Name] {
+			fmt.Fprintf(&buf, "%s=%s\n", f.Name, f.Value)
+		}
+	})
+	for _, name := range cachedFiles {
+		if filename := flag.Lookup(name).Value.String(); filename != "" {
+			hash, err := fileHash(filename)
+			if err != nil {
+				hash = err.String()
+			}
+			fmt.Fprintf(&buf, "%s:%s\n", name, hash)
+		}
+	}
+	return buf.String()
+}
+
+func fileHash(filename string) (hash string, err os.Error) {
+	var f *os.File
+	if f, err = os.Open(filename); err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	return fmt.Sprintf("%x", h.Sum()), nil
+}
+
+func loadCache(filename string, tcs [2]*Toolchain) (c *resultCache, err os.Error) {
+	c = &resultCache{
+		filename:  filename,
+		Entries:   make(map[string]*cacheEntry),
+		MaxRSS:    make(map[string]int64),
+		Durations: make(map[string]float64),
+	}
+	for i, tc := range tcs {
+		c.toolHashes[i] = toolsHash(tc)
+	}
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Error == os.ENOENT {
+			return c, nil
+		}
+		return
+	}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if c.MaxRSS == nil {
+		c.MaxRSS = make(map[string]int64)
+	}
+	if c.Durations == nil {
+		c.Durations = make(map[string]float64)
+	}
+	return
+}
+
+func (c *resultCache) key(test string, mode runMode) (key string, err os.Error) {
+	var testHash string
+	if testHash, err = fileHash(test); err != nil {
+		return
+	}
+	// The golden snapshot decides the golden status of the result.
+	goldenHash := ""
+	if *goldenDir != "" {
+		if goldenHash, err = fileHash(goldenPath(test)); err != nil {
+			goldenHash, err = "missing", nil
+		}
+	}
+	h := sha1.New()
+	io.WriteString(h, testHash+"\n"+c.toolHashes[0]+"\n"+c.toolHashes[1]+"\n"+goldenHash+"\n"+cacheOptions(mode))
+	return fmt.Sprintf("%x", h.Sum()), nil
+}
+
+// lookup returns the result stored for the test in the run mode, or nil if
+// there is none, its inputs have changed or it lacks the assembly or the logs
+// this run needs. The result still has to be post-processed like a fresh one.
+func (c *resultCache) lookup(test string, mode runMode) *Result {
+	if c == nil || *updateGolden {
+		return nil
+	}
+	key, err := c.key(test, mode)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	e, ok := c.Entries[key]
+	c.mu.Unlock()
+	if !ok || (keepsAsm(mode) && !e.KeptAsm) || (keepsLogs() && !e.KeptLogs) {
+		return nil
+	}
+	// The post-processing changes the stats, which stay as stored.
+	var stats [2]*Stats
+	for i, s := range e.Stats {
+		fresh := *s
+		fresh.Asm, fresh.stdout, fresh.stderr, fresh.Golden = e.Asm[i], e.Stdout[i], e.Stderr[i], ""
+		stats[i] = &fresh
+	}
+	return &Result{Test: test, Stats: stats, Identical: e.Identical, FunctionDiffs: e.FunctionDiffs, cached: true}
+}
+
+// store remembers the result of the test, measured in the run mode.
+func (c *resultCache) store(res *Result, mode runMode) {
+	if c == nil {
+		return
+	}
+	key, err := c.key(res.Test, mode)
+	if err != nil {
+		log.Printf("resultCache.key(%s): %v", res.Test, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &cacheEntry{Stats: res.Stats, Identical: res.Identical, FunctionDiffs: res.FunctionDiffs,
+		KeptAsm: keepsAsm(mode), KeptLogs: keepsLogs()}
+	for i, s := range res.Stats {
+		if e.KeptAsm {
+			e.Asm[i] = s.Asm
+		}
+		if e.KeptLogs {
+			e.Stdout[i], e.Stderr[i] = s.stdout, s.stderr
+		}
+	}
+	c.Entries[key] = e
+	duration := 0.0
+	for _, s := range res.Stats {
+		if s.MaxRSS > c.MaxRSS[res.Test] {
+			c.MaxRSS[res.Test] = s.MaxRSS
+		}
+		duration += s.WallSeconds
+	}
+	if duration > 0 {
+		c.Durations[res.Test] = duration
+	}
+}
+
+func (c *resultCache) duration(test string) float64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Durations[test]
+}
+
+func (c *resultCache) maxRSS(test string) int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.MaxRSS[test]
+}
+
+func (c *resultCache) save() (err os.Error) {
+	if c == nil {
+		return
+	}
+	var data []byte
+	if data, err = json.Marshal(c); err != nil {
+		return
+	}
+	return ioutil.WriteFile(c.filename, data, 0644)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+)
+
+// emptyModules are the inputs without code for each driver, whose compile
+// time is the fixed overhead of an invocation.
+var emptyModules = map[string]struct{ name, text string }{
+	"llc":   {"empty.ll", ""},
+	"clang": {"empty.c", ""},
+	"gcc":   {"empty.c", ""},
+	"rustc": {"empty.rs", "#![crate_type = \"lib\"]\n"},
+}
+
+// calibrate compiles an empty module -calibrate times and keeps the fastest
+// CPU and wall times as the invocation overhead of the toolchain.
+func (tc *Toolchain) calibrate() (err os.Error) {
+	module := emptyModules[tc.driver().Compiler()]
+	if pipeline != nil || module.name == "" {
+		module = emptyModules["llc"]
+	}
+	var dir string
+	if dir, err = ioutil.TempDir("", "llvm-side-by-side-calibrate"); err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	test := path.Join(dir, module.name)
+	if err = ioutil.WriteFile(test, []byte(module.text), 0644); err != nil {
+		return
+	}
+	for i := 0; i < *calibrateRuns; i++ {
+		var stats *Stats
+		if _, stats, err = tc.driver().Compile(tc, test, warmupRun); err != nil {
+			return fmt.Errorf("compiling an empty module: %v", err)
+		}
+		if i == 0 || stats.Seconds < tc.overhead.Seconds {
+			tc.overhead.Seconds = stats.Seconds
+		}
+		if i == 0 || stats.WallSeconds < tc.overhead.WallSeconds {
+			tc.overhead.WallSeconds = stats.WallSeconds
+		}
+		if i == 0 || stats.ProcessWallSeconds < tc.overhead.ProcessWallSeconds {
+			tc.overhead.ProcessWallSeconds = stats.ProcessWallSeconds
+		}
+	}
+	return
+}
+
+// calibrateOverhead measures the invocation overhead of both toolchains with
+// -calibrate and records it in the labels of the run.
+func calibrateOverhead(tcs [2]*Toolchain) (err os.Error) {
+	if *calibrateRuns <= 0 {
+		return
+	}
+	for _, tc := range tcs {
+		if err = tc.calibrate(); err != nil {
+			return fmt.Errorf("calibrate(%s): %v", tc.Name, err)
+		}
+		fmt.Printf("Invocation overhead of %s: %.4fs CPU, %.4fs wall\n", tc.Name, tc.overhead.Seconds,
+			tc.overhead.WallSeconds)
+		labels["overhead."+tc.Name] = fmt.Sprintf("%.4f/%.4f", tc.overhead.Seconds, tc.overhead.WallSeconds)
+	}
+	return
+}
+
+// subtractOverhead removes the invocation overhead of the toolchain from
+// the compile times with -subtract-overhead.
+func subtractOverhead(tc *Toolchain, stats *Stats) {
+	if !*subtractOverheadFlag {
+		return
+	}
+	stats.Seconds = math.Fmax(stats.Seconds-tc.overhead.Seconds, 0)
+	stats.WallSeconds = math.Fmax(stats.WallSeconds-tc.overhead.WallSeconds, 0)
+	stats.ProcessWallSeconds = math.Fmax(stats.ProcessWallSeconds-tc.overhead.ProcessWallSeconds, 0)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// casEntry is what an artifact store keeps of a run of a tool besides its
+// output file: what it printed and the resources it used, so that a cached
+// run still has its timings.
+type casEntry struct {
+	Stdout      string
+	Stderr      string
+	MaxRSS      int64
+	CPUSeconds  float64
+	WallSeconds float64
+}
+
+var (
+	toolHashesMu sync.Mutex
+	toolHashes   = make(map[string]string)
+)
+
+// toolHash hashes a tool binary once per run.
+func toolHash(tool string) (hash string, err os.Error) {
+	toolHashesMu.Lock()
+	defer toolHashesMu.Unlock()
+	if h, ok := toolHashes[tool]; ok {
+		return h, nil
+	}
+	if hash, err = fileHash(tool); err != nil {
+		return
+	}
+	toolHashes[tool] = hash
+	return
+}
+
+func dataHash(data []byte) string {
+	h := sha1.New()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum())
+}
+
+// casKey hashes what determines the output of a tool: its binary, its
+// arguments, which must not name temporary files, and the hash of its input.
+func casKey(tool string, args []string, inputHash string) (key string, err os.Error) {
+	var tHash string
+	if tHash, err = toolHash(tool); err != nil {
+		return
+	}
+	h := sha1.New()
+	io.WriteString(h, tHash+"\n"+strings.Join(args, "\x00")+"\n"+inputHash)
+	return fmt.Sprintf("%x", h.Sum()), nil
+}
+
+func casPath(dir, key string) string {
+	return filepath.Join(dir, key[:2], key)
+}
+
+// casLoad returns the stored run of the key and copies its output file to
+// outFile, unless outFile is empty.
+func casLoad(dir, key, outFile string) (out *testOutput, ok bool) {
+	p := casPath(dir, key)
+	data, err := ioutil.ReadFile(p + ".json")
+	if err != nil {
+		return nil, false
+	}
+	e := new(casEntry)
+	if json.Unmarshal(data, e) != nil {
+		return nil, false
+	}
+	if outFile != "" {
+		if data, err = ioutil.ReadFile(p + ".out"); err != nil {
+			return nil, false
+		}
+		if ioutil.WriteFile(outFile, data, 0644) != nil {
+			return nil, false
+		}
+	}
+	return &testOutput{stdout: e.Stdout, stderr: e.Stderr, maxRSS: e.MaxRSS, cpuSeconds: e.CPUSeconds,
+		wallSeconds: e.WallSeconds}, true
+}
+
+// casStore stores a run of a tool and its output file, unless outFile is
+// empty. A failure to store only costs a later run some time, so it is
+// ignored. The entry is written last, so that it is only found complete.
+func casStore(dir, key string, out *testOutput, outFile string) {
+	p := casPath(dir, key)
+	if os.MkdirAll(filepath.Dir(p), 0755) != nil {
+		return
+	}
+	if outFile != "" {
+		data, err := ioutil.ReadFile(outFile)
+		if err != nil || ioutil.WriteFile(p+".out", data, 0644) != nil {
+			return
+		}
+	}
+	data, err := json.Marshal(&casEntry{out.stdout, out.stderr, out.maxRSS, out.cpuSeconds, out.wallSeconds})
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(p+".json", data, 0644)
+}
+
+// runCached is runTimed through the -cas store, for the tools which read
+// stdin and write stdout.
+func runCached(tool string, args []string, input []byte) (out *testOutput, err os.Error) {
+	if *casDir == "" {
+		return runTimed(tool, args, input)
+	}
+	var key string
+	if key, err = casKey(tool, args, dataHash(input)); err != nil {
+		return
+	}
+	var ok bool
+	if out, ok = casLoad(*casDir, key, ""); ok {
+		return
+	}
+	if out, err = runTimed(tool, args, input); err == nil {
+		casStore(*casDir, key, out, "")
+	}
+	return
+}
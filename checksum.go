@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// checksums maps the URLs of remote tests to the SHA256 sums declared for
+// them in the -checksums file.
+var checksums map[string]string
+
+// loadChecksums reads a file in the format of sha256sum: a hex digest, white
+// space and a URL per line.
+func loadChecksums(filename string) (sums map[string]string, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	sums = make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != 64 {
+			return nil, fmt.Errorf("%s:%d: expected \"<sha256> <url>\"", filename, i+1)
+		}
+		// sha256sum marks binary files with a '*' before the name.
+		name := fields[1]
+		if name[0] == '*' {
+			name = name[1:]
+		}
+		sums[name] = strings.ToLower(fields[0])
+	}
+	return
+}
+
+// declaredChecksum returns the SHA256 sum declared for the URL, or "" if
+// there is none.
+func declaredChecksum(url string) (sum string, err os.Error) {
+	if *checksumsFile == "" {
+		return
+	}
+	if checksums == nil {
+		if checksums, err = loadChecksums(*checksumsFile); err != nil {
+			return
+		}
+	}
+	return checksums[url], nil
+}
+
+func fileSHA256(filename string) (sum string, err os.Error) {
+	var f *os.File
+	if f, err = os.Open(filename); err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	return fmt.Sprintf("%x", h.Sum()), nil
+}
+
+// verifyChecksum fails unless the file has the SHA256 sum want.
+func verifyChecksum(filename, want string) (err os.Error) {
+	var got string
+	if got, err = fileSHA256(filename); err != nil {
+		return
+	}
+	if got != strings.ToLower(want) {
+		return fmt.Errorf("%s has SHA256 %s, expected %s", filename, got, want)
+	}
+	return
+}
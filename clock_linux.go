@@ -0,0 +1,16 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const clockMonotonic = 1
+
+// monotonicNanoseconds reads CLOCK_MONOTONIC, which unlike the time of day
+// does not jump when the clock is set.
+func monotonicNanoseconds() int64 {
+	var ts syscall.Timespec
+	syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0)
+	return syscall.TimespecToNsec(ts)
+}
@@ -0,0 +1,9 @@
+package main
+
+import "time"
+
+// monotonicNanoseconds falls back to the time of day where there is no
+// monotonic clock to read.
+func monotonicNanoseconds() int64 {
+	return time.Nanoseconds()
+}
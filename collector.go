@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Artifacts are what one toolchain produced for one test.
+type Artifacts struct {
+	Toolchain *Toolchain
+	Test      string
+	Asm       string
+}
+
+// MetricCollector computes named values from the artifacts of a test. The
+// values are stored as "<collector name>.<value name>" metrics.
+type MetricCollector interface {
+	Name() string
+	Collect(a *Artifacts) (values map[string]float64, err os.Error)
+}
+
+var collectors []MetricCollector
+
+func registerCollector(c MetricCollector) {
+	collectors = append(collectors, c)
+}
+
+func findCollector(name string) MetricCollector {
+	for _, c := range collectors {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// collectMetrics runs every registered collector and adds their values to
+// the stats.
+func collectMetrics(a *Artifacts, stats *Stats) (err os.Error) {
+	for _, c := range collectors {
+		var values map[string]float64
+		if values, err = c.Collect(a); err != nil {
+			return fmt.Errorf("collector %s: %v", c.Name(), err)
+		}
+		if stats.Extra == nil {
+			stats.Extra = make(map[string]float64)
+		}
+		for k, v := range values {
+			stats.Extra[c.Name()+"."+k] = v
+		}
+	}
+	return
+}
+
+func extraMetric(name string) metric {
+	return metric{name, false, func(s *Stats) float64 { return s.Extra[name] }}
+}
+
+// externalCollector runs a command which reads the artifacts as a JSON
+// object on stdin and writes a JSON object of numbers to stdout.
+type externalCollector struct {
+	name    string
+	command string
+}
+
+type collectorInput struct {
+	Toolchain     string `json:"toolchain"`
+	ToolchainPath string `json:"toolchain_path"`
+	Test          string `json:"test"`
+	Asm           string `json:"asm"`
+}
+
+func (c *externalCollector) Name() string {
+	return c.name
+}
+
+func (c *externalCollector) Collect(a *Artifacts) (values map[string]float64, err os.Error) {
+	var input []byte
+	if input, err = json.Marshal(&collectorInput{a.Toolchain.Name, a.Toolchain.Path, a.Test, a.Asm}); err != nil {
+		return
+	}
+	cmd := shellCommand(c.command)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdin = bytes.NewBuffer(input)
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v, stderr: %s", c.command, err, errBuf.String())
+	}
+	if err = json.Unmarshal(outBuf.Bytes(), &values); err != nil {
+		return nil, fmt.Errorf("%s: %v", c.command, err)
+	}
+	return
+}
+
+var mcaRegexps = map[string]*regexp.Regexp{
+	"cycles":      regexp.MustCompile(`Total Cycles: +([0-9.]+)`),
+	"uops":        regexp.MustCompile(`Total uOps: +([0-9.]+)`),
+	"rthroughput": regexp.MustCompile(`Block RThroughput: +([0-9.]+)`),
+}
+
+// mcaCollector estimates the throughput of the assembly with the
+// toolchain's llvm-mca.
+type mcaCollector struct{}
+
+func (mcaCollector) Name() string {
+	return "mca"
+}
+
+func (mcaCollector) Collect(a *Artifacts) (values map[string]float64, err os.Error) {
+	var out []byte
+	if out, err = a.Toolchain.run([]byte(a.Asm), "llvm-mca"); err != nil {
+		return
+	}
+	values = make(map[string]float64)
+	for name, re := range mcaRegexps {
+		if ss := re.FindStringSubmatch(string(out)); ss != nil {
+			if values[name], err = strconv.Atof64(ss[1]); err != nil {
+				return nil, fmt.Errorf("llvm-mca %s: %v", name, err)
+			}
+		}
+	}
+	return
+}
+
+var builtinCollectors = map[string]MetricCollector{
+	"mca": mcaCollector{},
+}
+
+// collectorFlag registers a collector for each -collector flag.
+type collectorFlag struct{}
+
+func (collectorFlag) String() string {
+	var names []string
+	for _, c := range collectors {
+		names = append(names, c.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (collectorFlag) Set(s string) bool {
+	kv := strings.SplitN(s, "=", 2)
+	if kv[0] == "" || findCollector(kv[0]) != nil {
+		return false
+	}
+	if len(kv) == 2 {
+		registerCollector(&externalCollector{kv[0], kv[1]})
+		return true
+	}
+	c, ok := builtinCollectors[s]
+	if ok {
+		registerCollector(c)
+	}
+	return ok
+}
+
+func init() {
+	flag.Var(collectorFlag{}, "collector", "Collect extra metrics, named <collector>.<value>, with a built-in "+
+		"collector (mca) or with name=command, a command reading the test and its assembly as JSON on stdin "+
+		"and writing a JSON object of numbers; may be repeated")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name    string
+	summary string
+	// flags lists the global flags the subcommand accepts; nil means all.
+	flags []string
+	run   func(fs *flag.FlagSet, args []string)
+}
+
+// reportFlags are the global flags which only shape the report.
+var reportFlags = []string{"thresholds", "columns", "sort", "only-regressions", "html", "notes", "size-buckets",
+	"per-function", "categories", "exit-on", "template", "template-out", "symbol-diff",
+	"section-sizes", "diagnostics", "check-output", "output-abs-epsilon", "output-rel-epsilon", "output-tolerances", "xfail"}
+
+// commands lists the subcommands; the first one is the default.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"run", "Compare two toolchains on the tests", nil, runCommand},
+		{"report", "Print the report of a run stored with -out", reportFlags, reportCommand},
+		{"compare-runs", "Compare two stored runs", reportFlags, compareRuns},
+		{"digest", "Summarize the stored runs of the last days", []string{"thresholds"}, digestCommand},
+		{"triage", "Step through the divergent tests of a stored run and record the decisions", nil, triageCommand},
+		{"bisect", "Find the first build in a list of toolchains with which the tests regress", nil, bisectCommand},
+		{"fuzz", "Compare the toolchains on random modules from llvm-stress", nil, fuzzCommand},
+		{"matrix", "Compare the toolchains across combinations of llc flags", nil, matrixCommand},
+		{"serve", "Run comparison jobs submitted over HTTP", nil, serveCommand},
+		{"toolchains", "List the LLVM installations found on this machine", []string{}, toolchainsCommand},
+		{"help", "Describe the subcommands", []string{}, helpCommand},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns a flag set for the subcommand with the global flags it
+// accepts. The flags share their values with the global ones.
+func newFlagSet(c *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	if c.flags == nil {
+		flag.VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		})
+	}
+	for _, name := range c.flags {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	return fs
+}
+
+func helpCommand(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if c := findCommand(fs.Arg(0)); c != nil && c.name != "help" {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, c.summary)
+		// Let the subcommand define and print its own flags too.
+		c.run(newFlagSet(c), []string{"-help"})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "usage: llvm-side-by-side [subcommand] [flags] [tests]\n\nSubcommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nWithout a subcommand, run is assumed. Use help <subcommand> for its flags.\n")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// RunSet is the stats of one toolchain from one stored run, keyed by test.
+type RunSet struct {
+	Toolchain *Toolchain
+	Tests     []string
+	Stats     map[string]*Stats
+	Sizes     map[string]int64
+}
+
+// loadRunSet reads a run set given as <results.json>[#run_id][@toolchain].
+// By default the latest run in the file and its first toolchain are used.
+func loadRunSet(spec string) (set *RunSet, err os.Error) {
+	filename, runID, tcName := spec, "", ""
+	if i := strings.LastIndex(filename, "@"); i >= 0 {
+		filename, tcName = filename[:i], filename[i+1:]
+	}
+	if i := strings.LastIndex(filename, "#"); i >= 0 {
+		filename, runID = filename[:i], filename[i+1:]
+	}
+	var records []*Record
+	if records, err = readRecords(filename); err != nil {
+		return
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s has no results", filename)
+	}
+	if runID == "" {
+		runID = records[len(records)-1].RunID
+	}
+	set = &RunSet{Stats: make(map[string]*Stats), Sizes: make(map[string]int64)}
+	for _, rec := range records {
+		if rec.RunID != runID {
+			continue
+		}
+		idx := 0
+		if tcName != "" {
+			for idx = 0; idx < len(rec.Toolchains) && rec.Toolchains[idx] != tcName; idx++ {
+			}
+			if idx == len(rec.Toolchains) {
+				return nil, fmt.Errorf("run %s in %s has no toolchain %q", runID, filename, tcName)
+			}
+		}
+		if set.Toolchain == nil {
+			set.Toolchain = &Toolchain{Name: rec.Toolchains[idx] + "@" + runID}
+		}
+		if rec.Stats[idx] == nil {
+			// The test failed with this toolchain.
+			continue
+		}
+		if _, dup := set.Stats[rec.Test]; !dup {
+			set.Tests = append(set.Tests, rec.Test)
+		}
+		set.Stats[rec.Test] = rec.Stats[idx]
+		set.Sizes[rec.Test] = rec.InputBytes
+	}
+	if set.Toolchain == nil {
+		return nil, fmt.Errorf("%s has no run %s", filename, runID)
+	}
+	return
+}
+
+func compareRuns(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if args = fs.Args(); len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: compare-runs [flags] <results.json>[#run_id][@toolchain] "+
+			"<results.json>[#run_id][@toolchain]\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var sets [2]*RunSet
+	var tcs [2]*Toolchain
+	var err os.Error
+	for i, spec := range args {
+		if sets[i], err = loadRunSet(spec); err != nil {
+			log.Fatalf("loadRunSet: %v", err)
+		}
+		tcs[i] = sets[i].Toolchain
+	}
+	rep, err := newReport(tcs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, tst := range sets[0].Tests {
+		stats, ok := sets[1].Stats[tst]
+		if !ok {
+			log.Printf("compare-runs: %s is missing from %s", tst, args[1])
+			continue
+		}
+		rep.add(&Result{Test: tst, Stats: [2]*Stats{sets[0].Stats[tst], stats}, InputBytes: sets[0].Sizes[tst]})
+	}
+	rep.finish(nil)
+}
+
+// loadRun reads the results of a run given as <results.json>[#run_id], by
+// default the latest run in the file.
+func loadRun(spec string) (tcs [2]*Toolchain, results []*Result, err os.Error) {
+	filename, runID := spec, ""
+	if i := strings.LastIndex(filename, "#"); i >= 0 {
+		filename, runID = filename[:i], filename[i+1:]
+	}
+	var records []*Record
+	if records, err = readRecords(filename); err != nil {
+		return
+	}
+	if len(records) == 0 {
+		return tcs, nil, fmt.Errorf("%s has no results", filename)
+	}
+	if runID == "" {
+		runID = records[len(records)-1].RunID
+	}
+	for _, rec := range records {
+		if rec.RunID != runID {
+			continue
+		}
+		if tcs[0] == nil {
+			tcs = [2]*Toolchain{&Toolchain{Name: rec.Toolchains[0]}, &Toolchain{Name: rec.Toolchains[1]}}
+		}
+		res := &Result{Test: rec.Test, Stats: rec.Stats, Note: rec.Note, Identical: rec.Identical,
+			InputBytes: rec.InputBytes, Statuses: rec.Statuses, Errors: rec.Errors, Aliases: rec.Aliases,
+			Throttled: rec.Throttled}
+		if rec.Stats[0] == nil || rec.Stats[1] == nil {
+			res.Err = fmt.Errorf("the test did not run: %s/%s", rec.Statuses[0], rec.Statuses[1])
+		}
+		results = append(results, res)
+	}
+	if tcs[0] == nil {
+		return tcs, nil, fmt.Errorf("%s has no run %s", filename, runID)
+	}
+	return
+}
+
+// reportCommand prints the report of a stored run again, e.g. with other
+// thresholds or columns.
+func reportCommand(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: report [flags] <results.json>[#run_id]\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	tcs, results, err := loadRun(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("loadRun: %v", err)
+	}
+	rep, err := newReport(tcs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, res := range results {
+		rep.add(res)
+	}
+	rep.finish(nil)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	assertionRegexp  = regexp.MustCompile("Assertion `(.*)' failed")
+	fatalErrorRegexp = regexp.MustCompile(`LLVM ERROR: (.*)`)
+	// stackFrameRegexp matches both "0  llc 0x00000000010f8a5f symbol + 38"
+	// and "#0 0x00000000010f8a5f symbol /path/file.cpp:12:3".
+	stackFrameRegexp  = regexp.MustCompile(`^#?[0-9]+ +(?:[^ ]+ +)?0x[0-9a-fA-F]+ +(.+)$`)
+	frameSuffixRegexp = regexp.MustCompile(`( \+ [0-9]+| [^ ]+:[0-9]+(:[0-9]+)?| \([^ ]*\+0x[0-9a-fA-F]+\))$`)
+	// ignoredFrameRegexp matches the frames of the crash handling itself.
+	ignoredFrameRegexp = regexp.MustCompile(`PrintStackTrace|SignalHandler|RunSignalHandlers|CrashRecoveryContext|` +
+		`^__restore_rt|^raise|^abort|^__assert_fail|^gsignal|report_fatal_error|llvm_unreachable_internal|^0x`)
+	numberRegexp = regexp.MustCompile(`0x[0-9a-fA-F]+|[0-9]+`)
+)
+
+const signatureFrames = 3
+
+// llcFailure is returned by runTest when llc exits with an error.
+type llcFailure struct {
+	msg      *os.Waitmsg
+	stderr   string
+	timedOut bool
+}
+
+func (e *llcFailure) String() string {
+	if e.timedOut {
+		return fmt.Sprintf("timed out after %d seconds", *timeout)
+	}
+	return fmt.Sprintf("cmd.Wait: %v", e.msg)
+}
+
+// crashed reports whether llc died from a signal or an internal error
+// rather than rejecting its input or being killed for the timeout.
+func (e *llcFailure) crashed() bool {
+	if e.timedOut {
+		return false
+	}
+	return e.msg.Signaled() || strings.Contains(e.stderr, "Stack dump:") ||
+		strings.Contains(e.stderr, "PLEASE submit a bug report") || fatalErrorRegexp.MatchString(e.stderr)
+}
+
+// testError is a failure of one toolchain on a test.
+type testError struct {
+	tc   *Toolchain
+	test string
+	err  os.Error
+}
+
+func (e *testError) String() string {
+	return fmt.Sprintf("runTest(%s=%s, test=%s): %v", e.tc.Name, e.tc.Path, e.test, e.err)
+}
+
+// statusOf classifies the outcome of running llc on a test.
+func statusOf(err os.Error) string {
+	if err == nil {
+		return statusOK
+	}
+	if te, ok := err.(*testError); ok {
+		err = te.err
+	}
+	if _, ok := err.(*vetoError); ok {
+		return statusVetoed
+	}
+	if _, ok := err.(*runtimeCrash); ok {
+		return statusRunCrash
+	}
+	if lf, ok := err.(*llcFailure); ok {
+		if lf.timedOut {
+			return statusTimeout
+		}
+		if lf.crashed() {
+			return statusCrash
+		}
+	}
+	return statusFail
+}
+
+// runError holds the errors of the toolchains which failed a test.
+type runError struct {
+	errs [2]os.Error
+}
+
+func (e *runError) String() string {
+	var msgs []string
+	for _, err := range e.errs {
+		if err != nil {
+			msgs = append(msgs, err.String())
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ErrorInfo describes how a test failed with one toolchain, for the
+// machine-readable results.
+type ErrorInfo struct {
+	// Phase is where the test failed: verify, llc, a hook such as "pre-test
+	// hook", or run for the other steps of running a test.
+	Phase string `json:"phase"`
+	// Classification is the status of the test: FAIL, CRASH, TIMEOUT, etc.
+	Classification string `json:"classification"`
+	ExitCode       int    `json:"exit_code,omitempty"`
+	// Stderr holds the first lines of what the failing tool printed.
+	Stderr  string `json:"stderr,omitempty"`
+	Message string `json:"message"`
+	// Backtrace is the backtrace of a RUN_CRASH.
+	Backtrace string `json:"backtrace,omitempty"`
+}
+
+const stderrExcerptLines = 20
+
+func stderrExcerpt(stderr string) string {
+	lines := strings.SplitN(strings.TrimSpace(stderr), "\n", stderrExcerptLines+1)
+	if len(lines) > stderrExcerptLines {
+		lines[stderrExcerptLines] = "..."
+	}
+	return strings.Join(lines, "\n")
+}
+
+func newErrorInfo(phase string, err os.Error) *ErrorInfo {
+	info := &ErrorInfo{Phase: phase, Classification: statusOf(err), Message: err.String()}
+	if te, ok := err.(*testError); ok {
+		err = te.err
+	}
+	switch e := err.(type) {
+	case *llcFailure:
+		info.Phase = "llc"
+		if !e.timedOut && e.msg.Exited() {
+			info.ExitCode = e.msg.ExitStatus()
+		}
+		info.Stderr = stderrExcerpt(e.stderr)
+	case *vetoError:
+		info.Phase = e.stage + " hook"
+	case *runtimeCrash:
+		info.Phase = "execute"
+		if e.msg.Exited() {
+			info.ExitCode = e.msg.ExitStatus()
+		}
+		info.Stderr = stderrExcerpt(e.stderr)
+		info.Backtrace = e.backtrace
+	}
+	return info
+}
+
+type Crash struct {
+	Toolchain string
+	Signature string
+}
+
+// crashOf returns the crash behind the error, or nil if the error is not
+// an llc crash or the crash of a -run executable.
+func crashOf(err os.Error) *Crash {
+	te, ok := err.(*testError)
+	if !ok {
+		return nil
+	}
+	if rc, ok := te.err.(*runtimeCrash); ok {
+		return &Crash{Toolchain: te.tc.Name, Signature: runtimeSignature(rc)}
+	}
+	lf, ok := te.err.(*llcFailure)
+	if !ok || !lf.crashed() {
+		return nil
+	}
+	return &Crash{Toolchain: te.tc.Name, Signature: crashSignature(lf)}
+}
+
+// crashSignature identifies a crash by its assertion, its fatal error or
+// the top frames of its stack trace, with the numbers masked out so that
+// crashes in the same place on different inputs get the same signature.
+func crashSignature(lf *llcFailure) string {
+	if ss := assertionRegexp.FindStringSubmatch(lf.stderr); ss != nil {
+		return "assertion: " + ss[1]
+	}
+	if ss := fatalErrorRegexp.FindStringSubmatch(lf.stderr); ss != nil {
+		return "error: " + numberRegexp.ReplaceAllString(strings.TrimSpace(ss[1]), "N")
+	}
+	var frames []string
+	for _, line := range strings.Split(lf.stderr, "\n") {
+		ss := stackFrameRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if ss == nil {
+			continue
+		}
+		frame := frameSuffixRegexp.ReplaceAllString(strings.TrimSpace(ss[1]), "")
+		if ignoredFrameRegexp.MatchString(frame) {
+			continue
+		}
+		if frames = append(frames, frame); len(frames) == signatureFrames {
+			break
+		}
+	}
+	if len(frames) > 0 {
+		return "stack: " + strings.Join(frames, " < ")
+	}
+	return fmt.Sprintf("exit: %v", lf.msg)
+}
+
+type crashBucket struct {
+	crash Crash
+	tests []string
+}
+
+type byBucketSize []*crashBucket
+
+func (s byBucketSize) Len() int      { return len(s) }
+func (s byBucketSize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byBucketSize) Less(i, j int) bool {
+	if len(s[i].tests) != len(s[j].tests) {
+		return len(s[i].tests) > len(s[j].tests)
+	}
+	return s[i].crash.Signature < s[j].crash.Signature
+}
+
+func bucketCrashes(failed []*Result) (buckets []*crashBucket) {
+	index := make(map[string]*crashBucket)
+	for _, res := range failed {
+		if res.Crash == nil {
+			continue
+		}
+		key := res.Crash.Toolchain + "\x00" + res.Crash.Signature
+		b, ok := index[key]
+		if !ok {
+			b = &crashBucket{crash: *res.Crash}
+			index[key] = b
+			buckets = append(buckets, b)
+		}
+		b.tests = append(b.tests, res.Test)
+	}
+	sort.Sort(byBucketSize(buckets))
+	return
+}
+
+func printCrashBuckets(buckets []*crashBucket) {
+	fmt.Printf("\n%d crash signatures:\n", len(buckets))
+	for _, b := range buckets {
+		fmt.Printf("%d\t%s\t%s\n", len(b.tests), b.crash.Toolchain, b.crash.Signature)
+		for _, test := range b.tests {
+			fmt.Printf("\t%s\n", path.Base(test))
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+// dedupTests drops the tests with the same contents as an earlier test and
+// returns the duplicates of each remaining test.
+func dedupTests(tests []string) (unique []string, aliases map[string][]string) {
+	aliases = make(map[string][]string)
+	first := make(map[string]string)
+	for _, test := range tests {
+		hash, err := fileHash(test)
+		if err != nil {
+			// The run reports the tests which can not be read.
+			unique = append(unique, test)
+			continue
+		}
+		if orig, dup := first[hash]; dup {
+			aliases[orig] = append(aliases[orig], test)
+			continue
+		}
+		first[hash] = test
+		unique = append(unique, test)
+	}
+	return
+}
+
+func printAliases(results []*Result) {
+	n := 0
+	for _, res := range results {
+		n += len(res.Aliases)
+	}
+	if n == 0 {
+		return
+	}
+	fmt.Printf("\n%d duplicate tests were only run as the test with the same contents:\nduplicate\ttest\n", n)
+	for _, res := range results {
+		for _, alias := range res.Aliases {
+			fmt.Printf("%s\t%s\n", path.Base(alias), path.Base(res.Test))
+		}
+	}
+}
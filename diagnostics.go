@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// diagnosticRegexp matches a warning, remark or error of a compiler and
+// leaves out its location, e.g. "test.ll:3:5: " or "llc: ".
+var diagnosticRegexp = regexp.MustCompile(`\b(warning|remark|error): +(.+)$`)
+
+// parseDiagnostics returns the distinct diagnostics in the stderr of a
+// compiler, as "<kind>: <message>".
+func parseDiagnostics(stderr string) (diags []string) {
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(stderr, "\n") {
+		ss := diagnosticRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if ss == nil {
+			continue
+		}
+		if d := ss[1] + ": " + strings.TrimSpace(ss[2]); !seen[d] {
+			seen[d] = true
+			diags = append(diags, d)
+		}
+	}
+	return
+}
+
+// diagnosticCount counts the tests in which each toolchain printed a
+// diagnostic.
+type diagnosticCount struct {
+	diag  string
+	tests [2]int
+	// only is the index of the toolchain which alone printed it, or -1.
+	only int
+}
+
+type byTests []*diagnosticCount
+
+func (s byTests) Len() int      { return len(s) }
+func (s byTests) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byTests) Less(i, j int) bool {
+	ti, tj := s[i].tests[0]+s[i].tests[1], s[j].tests[0]+s[j].tests[1]
+	if ti != tj {
+		return ti > tj
+	}
+	return s[i].diag < s[j].diag
+}
+
+// printDiagnostics summarizes the diagnostics which only one toolchain
+// printed in a test, listing up to -diagnostics of them by the number of
+// tests, and counts the ones both printed.
+func printDiagnostics(tcs [2]*Toolchain, results []*Result) {
+	counts := make(map[string]*diagnosticCount)
+	both := make(map[string]bool)
+	for _, res := range results {
+		var printed [2]map[string]bool
+		for i, s := range res.Stats {
+			printed[i] = make(map[string]bool)
+			for _, d := range s.Diagnostics {
+				printed[i][d] = true
+			}
+		}
+		for i := range printed {
+			for d := range printed[i] {
+				if printed[1-i][d] {
+					both[d] = true
+					continue
+				}
+				c, ok := counts[d]
+				if !ok {
+					c = &diagnosticCount{diag: d, only: i}
+					counts[d] = c
+				}
+				if c.tests[i]++; c.only != i {
+					c.only = -1
+				}
+			}
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	var sorted []*diagnosticCount
+	for _, c := range counts {
+		sorted = append(sorted, c)
+	}
+	sort.Sort(byTests(sorted))
+	fmt.Printf("\n%d diagnostics were printed by only one toolchain in some tests; %d were printed by both:\n",
+		len(sorted), len(both))
+	for i, c := range sorted {
+		if i == *diagnosticsLimit {
+			fmt.Printf("... and %d more\n", len(sorted)-i)
+			break
+		}
+		switch c.only {
+		case -1:
+			fmt.Printf("%s appeared in %d tests with %s only and in %d with %s only\n", c.diag, c.tests[0],
+				tcs[0].Name, c.tests[1], tcs[1].Name)
+		default:
+			fmt.Printf("%s appeared in %d tests with %s only\n", c.diag, c.tests[c.only], tcs[c.only].Name)
+		}
+	}
+}
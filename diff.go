@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type diffOp struct {
+	Kind byte // ' ', '-' or '+'
+	Line string
+}
+
+// diffLines computes a line diff of a and b. Common prefixes and suffixes are
+// matched first, then lines which are unique in both inputs are used as
+// anchors (as in patience diff), and small remaining blocks are diffed with
+// the classic LCS dynamic programming.
+func diffLines(a, b []string) (ops []diffOp) {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		ops = append(ops, diffOp{' ', a[i]})
+		i++
+	}
+	j := 0
+	for j < len(a)-i && j < len(b)-i && a[len(a)-1-j] == b[len(b)-1-j] {
+		j++
+	}
+	ops = append(ops, diffMiddle(a[i:len(a)-j], b[i:len(b)-j])...)
+	for _, line := range a[len(a)-j:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return
+}
+
+func diffMiddle(a, b []string) (ops []diffOp) {
+	if len(a) == 0 || len(b) == 0 {
+		return replaceOps(a, b)
+	}
+	if len(a)*len(b) <= 1<<20 {
+		return lcsDiff(a, b)
+	}
+	anchors := uniqueAnchors(a, b)
+	if len(anchors) == 0 {
+		return replaceOps(a, b)
+	}
+	pa, pb := 0, 0
+	for _, an := range anchors {
+		ops = append(ops, diffLines(a[pa:an[0]], b[pb:an[1]])...)
+		ops = append(ops, diffOp{' ', a[an[0]]})
+		pa, pb = an[0]+1, an[1]+1
+	}
+	return append(ops, diffLines(a[pa:], b[pb:])...)
+}
+
+func replaceOps(a, b []string) (ops []diffOp) {
+	for _, line := range a {
+		ops = append(ops, diffOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{'+', line})
+	}
+	return
+}
+
+func lcsDiff(a, b []string) (ops []diffOp) {
+	n, m := len(a), len(b)
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return append(ops, replaceOps(a[i:], b[j:])...)
+}
+
+// uniqueAnchors returns the longest increasing sequence of index pairs of
+// lines which occur exactly once in both a and b.
+func uniqueAnchors(a, b []string) (anchors [][2]int) {
+	type occurrence struct {
+		countA, countB int
+		posA, posB     int
+	}
+	occ := make(map[string]*occurrence)
+	for i, line := range a {
+		o, ok := occ[line]
+		if !ok {
+			o = new(occurrence)
+			occ[line] = o
+		}
+		o.countA++
+		o.posA = i
+	}
+	for j, line := range b {
+		if o, ok := occ[line]; ok {
+			o.countB++
+			o.posB = j
+		}
+	}
+	var pairs [][2]int
+	for i, line := range a {
+		if o := occ[line]; o.countA == 1 && o.countB == 1 {
+			pairs = append(pairs, [2]int{i, o.posB})
+		}
+	}
+	// Patience sorting on the b positions; tails[k] is the index in pairs of
+	// the smallest tail of an increasing sequence of length k+1.
+	var tails []int
+	prev := make([]int, len(pairs))
+	for p, pair := range pairs {
+		k := sort.Search(len(tails), func(k int) bool { return pairs[tails[k]][1] >= pair[1] })
+		if k > 0 {
+			prev[p] = tails[k-1]
+		} else {
+			prev[p] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, p)
+		} else {
+			tails[k] = p
+		}
+	}
+	if len(tails) == 0 {
+		return
+	}
+	anchors = make([][2]int, len(tails))
+	for p, k := tails[len(tails)-1], len(tails)-1; k >= 0; p, k = prev[p], k-1 {
+		anchors[k] = pairs[p]
+	}
+	return
+}
+
+// unifiedDiff formats ops as a unified diff with the given number of context
+// lines. It returns an empty string if there are no changes.
+func unifiedDiff(nameA, nameB string, ops []diffOp, context int) string {
+	n := len(ops)
+	lineA, lineB := make([]int, n+1), make([]int, n+1)
+	for k, op := range ops {
+		lineA[k+1], lineB[k+1] = lineA[k], lineB[k]
+		if op.Kind != '+' {
+			lineA[k+1]++
+		}
+		if op.Kind != '-' {
+			lineB[k+1]++
+		}
+	}
+	var buf bytes.Buffer
+	for i := 0; i < n; {
+		for i < n && ops[i].Kind == ' ' {
+			i++
+		}
+		if i == n {
+			break
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for {
+			for end < n && ops[end].Kind != ' ' {
+				end++
+			}
+			next := end
+			for next < n && ops[next].Kind == ' ' && next-end < 2*context {
+				next++
+			}
+			if next < n && ops[next].Kind != ' ' {
+				end = next
+				continue
+			}
+			break
+		}
+		stop := end + context
+		if stop > n {
+			stop = n
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", lineA[start]+1, lineA[stop]-lineA[start],
+			lineB[start]+1, lineB[stop]-lineB[start])
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(&buf, "%c%s\n", op.Kind, op.Line)
+		}
+		i = stop
+	}
+	return buf.String()
+}
+
+// localLabelRegexp matches the assembler-local labels of basic blocks,
+// constant pools and the like, whose numbers shift when a block is added.
+var localLabelRegexp = regexp.MustCompile(`\bL(BB|tmp|CPI|JTI|func_end)?[0-9]+(_[0-9]+)?\b`)
+
+func maskLabels(lines []string) []string {
+	masked := make([]string, len(lines))
+	for i, line := range lines {
+		masked[i] = localLabelRegexp.ReplaceAllString(line, "L?")
+	}
+	return masked
+}
+
+// diffMasked diffs a and b ignoring the numbers of local labels. Lines which
+// only differ in them are reported as unchanged, with the text from b.
+func diffMasked(a, b []string) (ops []diffOp) {
+	i, j := 0, 0
+	for _, op := range diffLines(maskLabels(a), maskLabels(b)) {
+		switch op.Kind {
+		case ' ':
+			ops = append(ops, diffOp{' ', b[j]})
+			i++
+			j++
+		case '-':
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		case '+':
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return
+}
+
+// splitBlocks splits the assembly into blocks, each starting at a label.
+func splitBlocks(lines []string) (blocks [][]string) {
+	var cur []string
+	for _, line := range lines {
+		if classifyAsmLine(line).label != "" && len(cur) > 0 {
+			blocks = append(blocks, cur)
+			cur = nil
+		}
+		cur = append(cur, line)
+	}
+	if len(cur) > 0 {
+		blocks = append(blocks, cur)
+	}
+	return
+}
+
+// diffAsm diffs two assemblies block by block. The blocks are matched by
+// their contents with the local labels masked, so that an extra basic block
+// shows up as an insertion rather than a change of every later block, and
+// then the lines of the matched blocks and of each run of unmatched blocks
+// are diffed.
+func diffAsm(a, b []string) (ops []diffOp) {
+	blocks := [2][][]string{splitBlocks(a), splitBlocks(b)}
+	var keys [2][]string
+	for i, bs := range blocks {
+		for _, block := range bs {
+			keys[i] = append(keys[i], strings.Join(maskLabels(block), "\n"))
+		}
+	}
+	var removed, added []string
+	ia, ib := 0, 0
+	for _, op := range diffLines(keys[0], keys[1]) {
+		switch op.Kind {
+		case ' ':
+			ops = append(ops, diffMasked(removed, added)...)
+			removed, added = nil, nil
+			ops = append(ops, diffMasked(blocks[0][ia], blocks[1][ib])...)
+			ia++
+			ib++
+		case '-':
+			removed = append(removed, blocks[0][ia]...)
+			ia++
+		case '+':
+			added = append(added, blocks[1][ib]...)
+			ib++
+		}
+	}
+	return append(ops, diffMasked(removed, added)...)
+}
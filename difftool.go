@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"exec"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// difftoolCommand builds the -difftool command for two files. The
+// placeholders {1} and {2} are replaced with the files; without them, the
+// files are appended to the command.
+func difftoolCommand(file1, file2 string) *exec.Cmd {
+	args := strings.Fields(*difftool)
+	placed := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{1}") || strings.Contains(arg, "{2}") {
+			args[i] = strings.Replace(strings.Replace(arg, "{1}", file1, -1), "{2}", file2, -1)
+			placed = true
+		}
+	}
+	if !placed {
+		args = append(args, file1, file2)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd
+}
+
+// runDifftool lets the user pick the tests whose assembly differs and opens
+// each pick in -difftool. It reads the answers from the terminal, since the
+// standard input may hold the list of tests.
+func runDifftool(tcs [2]*Toolchain, results []*Result) {
+	var differing []*Result
+	for _, res := range results {
+		if !res.Identical && res.DiffFile != "" {
+			differing = append(differing, res)
+		}
+	}
+	if len(differing) == 0 {
+		return
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		log.Printf("runDifftool: %v", err)
+		return
+	}
+	defer tty.Close()
+	r := bufio.NewReader(tty)
+	for {
+		fmt.Printf("\nTests with different assembly:\n")
+		for i, res := range differing {
+			fmt.Printf("%4d %s\n", i+1, path.Base(res.Test))
+		}
+		fmt.Printf("Open which test in %s (number, or q to quit)? ", *difftool)
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil || line == "q" {
+			return
+		}
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 1 || n > len(differing) {
+			fmt.Printf("No test %q\n", line)
+			continue
+		}
+		test := differing[n-1].Test
+		cmd := difftoolCommand(artifactPath(test, "."+tcs[0].Name+".s"), artifactPath(test, "."+tcs[1].Name+".s"))
+		if err = cmd.Run(); err != nil {
+			log.Printf("%s: %v", *difftool, err)
+		}
+	}
+	panic("unreachable")
+}
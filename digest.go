@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+type runSummary struct {
+	ID         string
+	Timestamp  string
+	Toolchains [2]string
+	Results    map[string]*Result
+	Regressed  map[string]float64
+	Totals     [2][]float64
+}
+
+func summarizeRuns(records []*Record, limits map[string]float64) (runs []*runSummary) {
+	byID := make(map[string]*runSummary)
+	for _, rec := range records {
+		rs, ok := byID[rec.RunID]
+		if !ok {
+			rs = &runSummary{
+				ID:         rec.RunID,
+				Timestamp:  rec.Timestamp,
+				Toolchains: rec.Toolchains,
+				Results:    make(map[string]*Result),
+				Regressed:  make(map[string]float64),
+			}
+			for i := range rs.Totals {
+				rs.Totals[i] = make([]float64, len(metrics))
+			}
+			byID[rec.RunID] = rs
+			runs = append(runs, rs)
+		}
+		if rec.Stats[0] == nil || rec.Stats[1] == nil {
+			continue
+		}
+		res := &Result{Test: rec.Test, Stats: rec.Stats, Note: rec.Note}
+		rs.Results[rec.Test] = res
+		if severity := res.severity(limits); severity > 0 {
+			rs.Regressed[rec.Test] = severity
+		}
+		for i, st := range rec.Stats {
+			for j, m := range metrics {
+				rs.Totals[i][j] += m.value(st)
+			}
+		}
+	}
+	return
+}
+
+func runSeconds(rs *runSummary) int64 {
+	t, err := time.Parse(time.RFC3339, rs.Timestamp)
+	if err != nil {
+		return 0
+	}
+	return t.Seconds()
+}
+
+type digest struct {
+	Runs      []*runSummary
+	New       []string
+	Fixed     []string
+	Baseline  *runSummary
+	Latest    *runSummary
+	Generated string
+}
+
+func newDigest(runs []*runSummary) *digest {
+	d := &digest{
+		Runs:      runs,
+		Baseline:  runs[0],
+		Latest:    runs[len(runs)-1],
+		Generated: time.LocalTime().Format(time.RFC1123),
+	}
+	for test := range d.Latest.Regressed {
+		if _, ok := d.Baseline.Regressed[test]; !ok {
+			d.New = append(d.New, test)
+		}
+	}
+	for test := range d.Baseline.Regressed {
+		if _, ok := d.Latest.Regressed[test]; !ok {
+			d.Fixed = append(d.Fixed, test)
+		}
+	}
+	sort.Strings(d.New)
+	sort.Strings(d.Fixed)
+	return d
+}
+
+func (d *digest) subject() string {
+	return fmt.Sprintf("llvm-side-by-side digest: %d new regressions, %d fixed", len(d.New), len(d.Fixed))
+}
+
+func (d *digest) writeText(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "%s\n\n", d.subject())
+	fmt.Fprintf(buf, "%d runs from %s to %s\n", len(d.Runs), d.Baseline.Timestamp, d.Latest.Timestamp)
+	fmt.Fprintf(buf, "Comparing run %s against run %s\n\n", d.Latest.ID, d.Baseline.ID)
+	fmt.Fprintf(buf, "New regressions (%d):\n", len(d.New))
+	for _, test := range d.New {
+		res := d.Latest.Results[test]
+		fmt.Fprintf(buf, "  %s\t%.2f%%\t%s\n", path.Base(test), d.Latest.Regressed[test], res.Note)
+	}
+	fmt.Fprintf(buf, "\nFixed regressions (%d):\n", len(d.Fixed))
+	for _, test := range d.Fixed {
+		fmt.Fprintf(buf, "  %s\n", path.Base(test))
+	}
+	fmt.Fprintf(buf, "\nTrends (percent delta of the totals):\nrun\ttimestamp\ttests\tregressed")
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "\t%s", m.name)
+	}
+	fmt.Fprintf(buf, "\n")
+	for _, rs := range d.Runs {
+		fmt.Fprintf(buf, "%s\t%s\t%d\t%d", rs.ID, rs.Timestamp, len(rs.Results), len(rs.Regressed))
+		for j := range metrics {
+			fmt.Fprintf(buf, "\t%.2f", deltaPct(rs.Totals[0][j], rs.Totals[1][j]))
+		}
+		fmt.Fprintf(buf, "\n")
+	}
+}
+
+func (d *digest) writeHTML(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "<html>\n<body style=\"font-family: sans-serif\">\n<h2>%s</h2>\n", html.EscapeString(d.subject()))
+	fmt.Fprintf(buf, "<p>%d runs from %s to %s.<br>Comparing run %s against run %s.</p>\n", len(d.Runs),
+		html.EscapeString(d.Baseline.Timestamp), html.EscapeString(d.Latest.Timestamp),
+		html.EscapeString(d.Latest.ID), html.EscapeString(d.Baseline.ID))
+	fmt.Fprintf(buf, "<h3>New regressions (%d)</h3>\n<table border=\"1\" cellpadding=\"2\">\n", len(d.New))
+	for _, test := range d.New {
+		res := d.Latest.Results[test]
+		fmt.Fprintf(buf, "<tr><td>%s</td><td>%.2f%%</td><td>%s</td></tr>\n", html.EscapeString(path.Base(test)),
+			d.Latest.Regressed[test], html.EscapeString(res.Note))
+	}
+	fmt.Fprintf(buf, "</table>\n<h3>Fixed regressions (%d)</h3>\n<ul>\n", len(d.Fixed))
+	for _, test := range d.Fixed {
+		fmt.Fprintf(buf, "<li>%s</li>\n", html.EscapeString(path.Base(test)))
+	}
+	fmt.Fprintf(buf, "</ul>\n<h3>Trends</h3>\n<table border=\"1\" cellpadding=\"2\">\n")
+	fmt.Fprintf(buf, "<tr><th>run</th><th>timestamp</th><th>tests</th><th>regressed</th>")
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "<th>%s %%</th>", html.EscapeString(m.name))
+	}
+	fmt.Fprintf(buf, "</tr>\n")
+	for _, rs := range d.Runs {
+		fmt.Fprintf(buf, "<tr><td>%s</td><td>%s</td><td>%d</td><td>%d</td>", html.EscapeString(rs.ID),
+			html.EscapeString(rs.Timestamp), len(rs.Results), len(rs.Regressed))
+		for j := range metrics {
+			fmt.Fprintf(buf, "<td>%.2f</td>", deltaPct(rs.Totals[0][j], rs.Totals[1][j]))
+		}
+		fmt.Fprintf(buf, "</tr>\n")
+	}
+	fmt.Fprintf(buf, "</table>\n<p><small>Generated %s</small></p>\n</body>\n</html>\n", html.EscapeString(d.Generated))
+}
+
+func digestCommand(fs *flag.FlagSet, args []string) {
+	days := fs.Int("days", 7, "Only consider runs from the last this many days; 0 means all runs")
+	format := fs.String("format", "text", "Output format: text or html")
+	mail := fs.Bool("mail", false, "Prefix the output with mail headers, for piping into sendmail")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: digest [flags] <results.json>\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	limits, err := parseThresholds(*thresholds)
+	if err != nil {
+		log.Fatalf("parseThresholds: %v", err)
+	}
+	records, err := readRecords(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("readRecords: %v", err)
+	}
+	var runs []*runSummary
+	since := time.Seconds() - int64(*days)*24*60*60
+	for _, rs := range summarizeRuns(records, limits) {
+		if *days == 0 || runSeconds(rs) >= since {
+			runs = append(runs, rs)
+		}
+	}
+	if len(runs) == 0 {
+		log.Fatalf("No runs in %s within the last %d days", fs.Arg(0), *days)
+	}
+
+	d := newDigest(runs)
+	var buf bytes.Buffer
+	switch *format {
+	case "text":
+		if *mail {
+			fmt.Fprintf(&buf, "Subject: %s\nContent-Type: text/plain; charset=utf-8\n\n", d.subject())
+		}
+		d.writeText(&buf)
+	case "html":
+		if *mail {
+			fmt.Fprintf(&buf, "Subject: %s\nMIME-Version: 1.0\nContent-Type: text/html; charset=utf-8\n\n", d.subject())
+		}
+		d.writeHTML(&buf)
+	default:
+		log.Fatalf("Unknown digest format: %s", *format)
+	}
+	os.Stdout.Write(buf.Bytes())
+}
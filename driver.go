@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Driver compiles a test with a toolchain into assembly, so that toolchains
+// other than llc can be compared too, e.g. clang with GCC on C sources.
+type Driver interface {
+	// Compile returns the assembly and the statistics the compiler reports.
+	Compile(tc *Toolchain, test string, mode runMode) (asm string, stats *Stats, err os.Error)
+	// Compiler is the name of the compiler in the bin directory.
+	Compiler() string
+}
+
+var drivers = map[string]Driver{
+	"llc":   llcDriver{},
+	"clang": clangDriver{},
+	"gcc":   gccDriver{},
+	"rustc": rustcDriver{},
+}
+
+func (tc *Toolchain) driver() Driver {
+	if pipeline != nil {
+		return pipelineDriver{}
+	}
+	if tc.Driver == "" {
+		return drivers["llc"]
+	}
+	return drivers[tc.Driver]
+}
+
+// llcDriver compiles bitcode and textual IR with llc.
+type llcDriver struct{}
+
+func (llcDriver) Compiler() string {
+	return "llc"
+}
+
+func (llcDriver) Compile(tc *Toolchain, test string, mode runMode) (asm string, stats *Stats, err os.Error) {
+	var input []byte
+	if input, err = prepareInput(tc, test); err != nil {
+		return "", nil, fmt.Errorf("prepareInput: %v", err)
+	}
+	var out *testOutput
+	if out, err = runTest(tc, input, mode); err != nil {
+		return
+	}
+	stats = parseTestOutput(tc, out.stderr)
+	stats.MaxRSS, stats.ProcessWallSeconds = out.maxRSS, out.wallSeconds
+	stats.stdout, stats.stderr = out.stdout, out.stderr
+	return out.stdout, stats, nil
+}
+
+// clangDriver compiles sources, or IR, with clang -S. The LLVM statistics and
+// pass timings are passed through with -mllvm -stats and -ftime-report.
+type clangDriver struct{}
+
+func (clangDriver) Compiler() string {
+	return "clang"
+}
+
+func (clangDriver) Compile(tc *Toolchain, test string, mode runMode) (asm string, stats *Stats, err os.Error) {
+	args := append([]string{"-S", "-o", "-"}, strings.Fields(*ccFlags)...)
+	if mode.stats {
+		args = append(args, "-mllvm", "-stats")
+	}
+	if mode.timePasses {
+		args = append(args, "-ftime-report")
+	}
+	var out *testOutput
+	if out, err = runTimed(tc.tool("clang"), append(args, inputFor(tc, test)), nil); err != nil {
+		return
+	}
+	stats = parseTestOutput(tc, out.stderr)
+	stats.MaxRSS, stats.ProcessWallSeconds = out.maxRSS, out.wallSeconds
+	stats.stdout, stats.stderr = out.stdout, out.stderr
+	return out.stdout, stats, nil
+}
+
+// gccDriver compiles sources with gcc -S. GCC has no LLVM statistics, so the
+// instructions are counted in the assembly and the time is measured from
+// outside.
+type gccDriver struct{}
+
+func (gccDriver) Compiler() string {
+	return "gcc"
+}
+
+func (gccDriver) Compile(tc *Toolchain, test string, mode runMode) (asm string, stats *Stats, err os.Error) {
+	args := append([]string{"-S", "-o", "-"}, strings.Fields(*ccFlags)...)
+	var out *testOutput
+	if out, err = runTimed(tc.tool("gcc"), append(args, inputFor(tc, test)), nil); err != nil {
+		return
+	}
+	stats = &Stats{Counters: make(map[string]int), MaxRSS: out.maxRSS, Seconds: out.cpuSeconds,
+		WallSeconds: out.wallSeconds, ProcessWallSeconds: out.wallSeconds, Diagnostics: parseDiagnostics(out.stderr),
+		stdout: out.stdout, stderr: out.stderr}
+	for _, n := range countFunctionInstrs(out.stdout) {
+		stats.AsmInstrs += n
+	}
+	return out.stdout, stats, nil
+}
+
+// rustcDriver compiles Rust crates with rustc --emit=asm. The LLVM
+// statistics and pass timings are passed through with -C llvm-args.
+type rustcDriver struct{}
+
+func (rustcDriver) Compiler() string {
+	return "rustc"
+}
+
+func (rustcDriver) Compile(tc *Toolchain, test string, mode runMode) (asm string, stats *Stats, err os.Error) {
+	var dir string
+	if dir, err = ioutil.TempDir("", "llvm-side-by-side-rustc"); err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	asmFile := path.Join(dir, "out.s")
+	// A single codegen unit keeps the whole crate in one assembly file.
+	args := append([]string{"--emit=asm", "-o", asmFile, "-C", "codegen-units=1"}, strings.Fields(*rustcFlags)...)
+	if mode.stats {
+		args = append(args, "-C", "llvm-args=-stats")
+	}
+	if mode.timePasses {
+		args = append(args, "-C", "llvm-args=-time-passes")
+	}
+	var out *testOutput
+	if out, err = runTimed(tc.tool("rustc"), append(args, inputFor(tc, test)), nil); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = ioutil.ReadFile(asmFile); err != nil {
+		return
+	}
+	stats = parseTestOutput(tc, out.stderr)
+	stats.MaxRSS, stats.ProcessWallSeconds = out.maxRSS, out.wallSeconds
+	stats.stdout, stats.stderr = out.stdout, out.stderr
+	return string(data), stats, nil
+}
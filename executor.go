@@ -0,0 +1,223 @@
+package main
+
+import (
+	"debug/elf"
+	"exec"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// hostArchs maps GOARCH to the architecture names of qemu-user.
+var hostArchs = map[string]string{
+	"386":     "i386",
+	"amd64":   "x86_64",
+	"arm":     "arm",
+	"arm64":   "aarch64",
+	"riscv64": "riscv64",
+	"ppc64":   "ppc64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// The ELF machines newer than debug/elf.
+const (
+	emAArch64 = 183
+	emRISCV   = 243
+)
+
+// elfArch returns the qemu-user architecture name of an ELF executable, or
+// "" if it is not known.
+func elfArch(exeFile string) string {
+	f, err := elf.Open(exeFile)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	le := f.Data == elf.ELFDATA2LSB
+	is64 := f.Class == elf.ELFCLASS64
+	switch int(f.Machine) {
+	case int(elf.EM_386):
+		return "i386"
+	case int(elf.EM_X86_64):
+		return "x86_64"
+	case int(elf.EM_ARM):
+		if le {
+			return "arm"
+		}
+		return "armeb"
+	case emAArch64:
+		if le {
+			return "aarch64"
+		}
+		return "aarch64_be"
+	case emRISCV:
+		if is64 {
+			return "riscv64"
+		}
+		return "riscv32"
+	case int(elf.EM_PPC64):
+		if le {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case int(elf.EM_MIPS):
+		switch {
+		case is64 && le:
+			return "mips64el"
+		case is64:
+			return "mips64"
+		case le:
+			return "mipsel"
+		}
+		return "mips"
+	case int(elf.EM_S390):
+		return "s390x"
+	}
+	return ""
+}
+
+// executeCommand returns the command and arguments which run a produced
+// executable: the executable itself, or qemu-user with -sysroot as its -L
+// if it is for another architecture than the host, with -qemu overriding
+// the qemu-<arch> binary.
+func executeCommand(exeFile string, args []string) (command string, cmdArgs []string) {
+	arch := elfArch(exeFile)
+	if arch == "" || arch == hostArchs[runtime.GOARCH] {
+		return exeFile, args
+	}
+	command = *qemuBin
+	if command == "" {
+		command = "qemu-" + arch
+	}
+	if *sysroot != "" {
+		cmdArgs = append(cmdArgs, "-L", *sysroot)
+	}
+	cmdArgs = append(cmdArgs, exeFile)
+	return command, append(cmdArgs, args...)
+}
+
+// linkArgs are the clang flags which link a produced executable for
+// -run-target.
+func linkArgs() (args []string) {
+	if *runTarget != "" {
+		args = append(args, "--target="+*runTarget)
+	}
+	if *sysroot != "" {
+		args = append(args, "--sysroot="+*sysroot)
+	}
+	return
+}
+
+// device runs the produced executables on another machine over adb or ssh,
+// from a -device spec: adb, adb:<serial> or ssh:[<user>@]<host>.
+type device struct {
+	kind   string
+	target string
+}
+
+// dev is nil without -device.
+var dev *device
+
+func parseDevice(spec string) (d *device, err os.Error) {
+	parts := strings.SplitN(spec, ":", 2)
+	d = &device{kind: parts[0]}
+	if len(parts) == 2 {
+		d.target = parts[1]
+	}
+	switch {
+	case d.kind == "adb":
+	case d.kind == "ssh" && d.target != "":
+	default:
+		return nil, fmt.Errorf("device %q is not adb, adb:<serial> or ssh:[<user>@]<host>", spec)
+	}
+	return
+}
+
+// tool returns adb or ssh with the flags which select the device.
+func (d *device) tool(args ...string) (command string, cmdArgs []string) {
+	if d.kind == "ssh" {
+		return "ssh", append([]string{d.target}, args...)
+	}
+	if d.target != "" {
+		return "adb", append([]string{"-s", d.target}, args...)
+	}
+	return "adb", args
+}
+
+// push copies the executable to a temporary directory of the device and
+// returns its path there.
+func (d *device) push(exeFile string) (remote string, err os.Error) {
+	var cmd *exec.Cmd
+	if d.kind == "ssh" {
+		remote = path.Join("/tmp", path.Base(exeFile))
+		cmd = exec.Command("scp", "-q", exeFile, d.target+":"+remote)
+	} else {
+		remote = path.Join("/data/local/tmp", path.Base(exeFile))
+		name, args := d.tool("push", exeFile, remote)
+		cmd = exec.Command(name, args...)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pushing %s to the device: %v, output: %s", exeFile, err, out)
+	}
+	return
+}
+
+// remove deletes an executable pushed to the device.
+func (d *device) remove(remote string) {
+	args := []string{"rm", "-f", shellQuote(remote)}
+	if d.kind != "ssh" {
+		args = append([]string{"shell"}, args...)
+	}
+	name, args := d.tool(args...)
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		log.Printf("removing %s from the device: %v, output: %s", remote, err, out)
+	}
+}
+
+// deviceTimeMarker prefixes the line with the run time, in nanoseconds,
+// which the device prints to stderr after the executable exits.
+const deviceTimeMarker = "llvm-side-by-side-ns: "
+
+var deviceTimeRegexp = regexp.MustCompile(deviceTimeMarker + `([0-9]+)\n?$`)
+
+// command returns the command which runs the executable on the device and
+// times it there, so that the adb or ssh overhead is not counted.
+func (d *device) command(remote string, spec *runSpec) (command string, cmdArgs []string) {
+	script := fmt.Sprintf(`chmod 755 %s && s=$(date +%%s%%N); %s; r=$?; e=$(date +%%s%%N); `+
+		`echo "%s$((e-s))" >&2; exit $r`, shellQuote(remote), spec.commandLine(remote), deviceTimeMarker)
+	if d.kind == "ssh" {
+		return d.tool(script)
+	}
+	return d.tool("shell", script)
+}
+
+// runExecutable runs a produced executable once: locally, under qemu-user,
+// or on the -device, where exeFile is the path pushed to. On a device, the
+// wall time is the one measured there and there is no CPU time.
+func runExecutable(exeFile string, spec *runSpec) (out *testOutput, err os.Error) {
+	if dev == nil {
+		command, args := spec.command(exeFile)
+		return runTimed(command, args, spec.stdin)
+	}
+	command, args := dev.command(exeFile, spec)
+	if out, err = runTimed(command, args, spec.stdin); err != nil {
+		return
+	}
+	ss := deviceTimeRegexp.FindStringSubmatchIndex(out.stderr)
+	if ss == nil {
+		return nil, fmt.Errorf("the device did not report the run time, stderr: %s", out.stderr)
+	}
+	ns, err := strconv.Atoi64(out.stderr[ss[2]:ss[3]])
+	if err != nil {
+		return
+	}
+	out.stderr = out.stderr[:ss[0]]
+	out.wallSeconds, out.cpuSeconds = float64(ns)/1e9, 0
+	return
+}
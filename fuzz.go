@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func parseSeedRange(spec string) (first, last int, err os.Error) {
+	parts := strings.SplitN(spec, "-", 2)
+	if first, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("seed range %q: %v", spec, err)
+	}
+	last = first
+	if len(parts) == 2 {
+		if last, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("seed range %q: %v", spec, err)
+		}
+	}
+	if last < first {
+		return 0, 0, fmt.Errorf("seed range %q is empty", spec)
+	}
+	return
+}
+
+// fuzzCommand generates random modules with llvm-stress and compares the
+// toolchains on them. The modules which neither crash nor diverge are
+// removed unless -keep-all is given.
+func fuzzCommand(fs *flag.FlagSet, args []string) {
+	seeds := fs.String("seeds", "1-100", "Range of llvm-stress seeds, as first-last")
+	size := fs.Int("size", 100, "Size of the generated modules, passed to llvm-stress -size")
+	dir := fs.String("dir", "fuzz", "Directory for the generated modules")
+	keepAll := fs.Bool("keep-all", false, "Keep the modules which neither crash nor diverge")
+	fs.Parse(args)
+	tcs := parseToolchains()
+	first, last, err := parseSeedRange(*seeds)
+	if err != nil {
+		log.Fatalf("parseSeedRange: %v", err)
+	}
+	if err = os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatalf("os.MkdirAll: %v", err)
+	}
+
+	// The modules are generated with the first toolchain's llvm-stress and
+	// assembled with each toolchain's own llvm-as.
+	var tests []string
+	for seed := first; seed <= last; seed++ {
+		ir, err := tcs[0].run(nil, "llvm-stress", fmt.Sprintf("-seed=%d", seed), fmt.Sprintf("-size=%d", *size))
+		if err != nil {
+			// One bad seed should not stop the rest of the batch.
+			log.Printf("fuzzCommand: llvm-stress: %v", err)
+			continue
+		}
+		name := path.Join(*dir, fmt.Sprintf("stress-%d.ll", seed))
+		if err = ioutil.WriteFile(name, ir, 0644); err != nil {
+			log.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		tests = append(tests, name)
+	}
+	if len(tests) == 0 {
+		log.Fatalf("llvm-stress failed for every seed in %s", *seeds)
+	}
+
+	run, rep, err := compareTests(tcs, tests)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*keepAll {
+		for _, res := range rep.results {
+			if !res.diverged() {
+				os.Remove(res.Test)
+			}
+		}
+	}
+	rep.finish(run)
+}
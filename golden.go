@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	goldenMatch    = "match"
+	goldenMismatch = "mismatch"
+	goldenMissing  = "missing"
+	goldenUpdated  = "updated"
+)
+
+// goldenName names the snapshot of the test by its path relative to the
+// -golden-root, so that tests with the same base name in different
+// directories have their own snapshots. A test from an archive is named by
+// the archive and its path in it, a test outside of the root by its absolute
+// path.
+func goldenName(test string) string {
+	for dir, name := range archiveNames {
+		if strings.HasPrefix(test, dir+"/") {
+			return path.Join(name, test[len(dir)+1:])
+		}
+	}
+	wd, _ := os.Getwd()
+	root := *goldenRoot
+	if !path.IsAbs(root) {
+		root = path.Join(wd, root)
+	}
+	if !path.IsAbs(test) {
+		test = path.Join(wd, test)
+	}
+	if strings.HasPrefix(test, root+"/") {
+		return test[len(root)+1:]
+	}
+	return strings.TrimLeft(test, "/")
+}
+
+func goldenPath(test string) string {
+	return path.Join(*goldenDir, goldenName(test)+".s")
+}
+
+// checkGolden compares the normalized assembly of both toolchains with the
+// snapshot of the test. With -update-golden, the snapshot is replaced with
+// the first toolchain's assembly first.
+func checkGolden(res *Result) (err os.Error) {
+	name := goldenPath(res.Test)
+	if *updateGolden {
+		if err = os.MkdirAll(path.Dir(name), 0755); err != nil {
+			return
+		}
+		if err = ioutil.WriteFile(name, []byte(normalizeAsm(res.Stats[0].Asm)+"\n"), 0644); err != nil {
+			return
+		}
+		res.Stats[0].Golden = goldenUpdated
+	}
+	data, err := ioutil.ReadFile(name)
+	if pe, ok := err.(*os.PathError); ok && pe.Error == os.ENOENT {
+		for _, s := range res.Stats {
+			s.Golden = goldenMissing
+		}
+		return nil
+	} else if err != nil {
+		return
+	}
+	golden := strings.TrimSpace(string(data))
+	for _, s := range res.Stats {
+		if s.Golden != "" {
+			continue
+		}
+		if normalizeAsm(s.Asm) == golden {
+			s.Golden = goldenMatch
+		} else {
+			s.Golden = goldenMismatch
+		}
+	}
+	return
+}
+
+func (r *Result) goldenMismatch() bool {
+	return r.Stats[0].Golden == goldenMismatch || r.Stats[1].Golden == goldenMismatch
+}
+
+func printGoldenMismatches(tcs [2]*Toolchain, results []*Result) {
+	var mismatched []*Result
+	for _, res := range results {
+		if res.goldenMismatch() {
+			mismatched = append(mismatched, res)
+		}
+	}
+	if len(mismatched) == 0 {
+		return
+	}
+	fmt.Printf("\n%d tests do not match their golden snapshots:\ntest\t%s\t%s\n",
+		len(mismatched), tcs[0].Name, tcs[1].Name)
+	for _, res := range mismatched {
+		fmt.Printf("%s\t%s\t%s\n", path.Base(res.Test), res.Stats[0].Golden, res.Stats[1].Golden)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"json"
+	"os"
+)
+
+const statusVetoed = "VETOED"
+
+// hookOutput is what a hook may print as a JSON object. An empty output
+// changes nothing.
+type hookOutput struct {
+	// Metrics are stored as "hook.<name>". The post-compare hook gives one
+	// map per toolchain.
+	Metrics  map[string]float64    `json:"metrics"`
+	Metrics2 [2]map[string]float64 `json:"toolchain_metrics"`
+	// Veto drops the result of the test with this reason.
+	Veto string `json:"veto"`
+}
+
+// vetoError is returned when a hook vetoes a test.
+type vetoError struct {
+	stage  string
+	reason string
+}
+
+func (e *vetoError) String() string {
+	return fmt.Sprintf("vetoed by the %s hook: %s", e.stage, e.reason)
+}
+
+// runHook runs the command of a hook with shellCommand.
+func runHook(stage, command string, args ...string) (out *hookOutput, err os.Error) {
+	cmd := shellCommand(command, args...)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s hook: %v, stderr: %s", stage, err, errBuf.String())
+	}
+	out = new(hookOutput)
+	if len(bytes.TrimSpace(outBuf.Bytes())) == 0 {
+		return
+	}
+	if err = json.Unmarshal(outBuf.Bytes(), out); err != nil {
+		return nil, fmt.Errorf("%s hook: %v", stage, err)
+	}
+	if out.Veto != "" {
+		return nil, &vetoError{stage, out.Veto}
+	}
+	return
+}
+
+func addHookMetrics(stats *Stats, values map[string]float64) {
+	if len(values) == 0 {
+		return
+	}
+	if stats.Extra == nil {
+		stats.Extra = make(map[string]float64)
+	}
+	for k, v := range values {
+		stats.Extra["hook."+k] = v
+	}
+}
+
+// writeTemp saves data in a temporary file for a hook; the caller removes it.
+func writeTemp(prefix string, data string) (name string, err os.Error) {
+	var f *os.File
+	if f, err = ioutil.TempFile("", prefix); err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = f.WriteString(data); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	return f.Name(), nil
+}
+
+// preTestHook runs the -pre-test-hook with the path of the test and returns
+// a failed result if it vetoes the test.
+func preTestHook(test string) *Result {
+	if *preTestCmd == "" {
+		return nil
+	}
+	if _, err := runHook("pre-test", *preTestCmd, test); err != nil {
+		return hookFailure(test, "pre-test", err)
+	}
+	return nil
+}
+
+// postCompileHook runs the -post-compile-hook with the toolchain name, the
+// path of the test and the path of its assembly.
+func postCompileHook(tc *Toolchain, test, asm string, stats *Stats) (err os.Error) {
+	if *postCompileCmd == "" {
+		return
+	}
+	var name string
+	if name, err = writeTemp("post-compile", asm); err != nil {
+		return
+	}
+	defer os.Remove(name)
+	var out *hookOutput
+	if out, err = runHook("post-compile", *postCompileCmd, tc.Name, test, name); err != nil {
+		return
+	}
+	addHookMetrics(stats, out.Metrics)
+	return
+}
+
+// postCompareHook runs the -post-compare-hook with the path of the test and
+// the paths of both assemblies. It returns a failed result if the hook fails
+// or vetoes the test.
+func postCompareHook(res *Result) *Result {
+	if *postCompareCmd == "" {
+		return res
+	}
+	var names [2]string
+	for i, s := range res.Stats {
+		name, err := writeTemp("post-compare", s.Asm)
+		if err != nil {
+			return hookFailure(res.Test, "post-compare", err)
+		}
+		defer os.Remove(name)
+		names[i] = name
+	}
+	out, err := runHook("post-compare", *postCompareCmd, res.Test, names[0], names[1])
+	if err != nil {
+		return hookFailure(res.Test, "post-compare", err)
+	}
+	addHookMetrics(res.Stats[0], out.Metrics)
+	addHookMetrics(res.Stats[1], out.Metrics)
+	for i, s := range res.Stats {
+		addHookMetrics(s, out.Metrics2[i])
+	}
+	return res
+}
+
+func hookFailure(test, stage string, err os.Error) *Result {
+	info := newErrorInfo(stage+" hook", err)
+	return &Result{Test: test, Err: err, Statuses: [2]string{info.Classification, info.Classification},
+		Errors: []*ErrorInfo{info, info}}
+}
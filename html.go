@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+)
+
+const htmlHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+th { cursor: pointer; background: #ddd; }
+th, td { padding: 2px 8px; border: 1px solid #ccc; text-align: right; }
+td.test { text-align: left; cursor: pointer; }
+tr.regressed td { background: #fdd; }
+tr.detail td { text-align: left; background: #f8f8f8; }
+div.chart { display: inline-block; margin: 0 16px 16px 0; }
+</style>
+<script>
+function sortBy(col) {
+  var table = document.getElementById('results');
+  var bodies = Array.prototype.slice.call(table.tBodies);
+  var desc = table.getAttribute('data-sort') == col + ':asc';
+  bodies.sort(function(a, b) {
+    var x = a.rows[0].cells[col].getAttribute('data-value');
+    var y = b.rows[0].cells[col].getAttribute('data-value');
+    var nx = parseFloat(x), ny = parseFloat(y);
+    var c = (isNaN(nx) || isNaN(ny)) ? (x < y ? -1 : (x > y ? 1 : 0)) : (nx < ny ? -1 : (nx > ny ? 1 : 0));
+    return desc ? -c : c;
+  });
+  for (var i = 0; i < bodies.length; i++) {
+    table.appendChild(bodies[i]);
+  }
+  table.setAttribute('data-sort', col + (desc ? ':desc' : ':asc'));
+}
+function filterRows(text) {
+  var bodies = document.getElementById('results').tBodies;
+  for (var i = 0; i < bodies.length; i++) {
+    var match = bodies[i].rows[0].textContent.indexOf(text) >= 0;
+    bodies[i].style.display = match ? '' : 'none';
+  }
+}
+function toggle(body) {
+  var row = body.rows[1];
+  row.style.display = row.style.display == 'none' ? '' : 'none';
+}
+</script>
+</head>
+<body>
+<h1>%s</h1>
+<p>Filter: <input type="text" onkeyup="filterRows(this.value)"> &nbsp; Click a column header to sort, a test name to show its details.</p>
+`
+
+// jsNumber formats v so that the report's JavaScript can parse it back.
+func jsNumber(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return fmt.Sprint(v)
+}
+
+func writeHTMLReport(filename string, tcs [2]*Toolchain, cs *columnSet, limits map[string]float64,
+	results []*Result, matrix [2][2]int) (err os.Error) {
+	var buf bytes.Buffer
+	title := html.EscapeString(fmt.Sprintf("llvm-side-by-side: %s vs %s", tcs[0].Name, tcs[1].Name))
+	fmt.Fprintf(&buf, htmlHead, title, title)
+	if len(labels) > 0 {
+		fmt.Fprintf(&buf, "<p>Labels: %s</p>\n", html.EscapeString(labels.String()))
+	}
+
+	writeStatusMatrix(&buf, tcs, matrix)
+	writeCharts(&buf, tcs, cs, results)
+	if *sizeBuckets != "" {
+		if buckets, err := bucketResults(*sizeBuckets, cs.metrics, results); err == nil {
+			writeBuckets(&buf, cs.metrics, buckets)
+		}
+	}
+
+	fmt.Fprintf(&buf, "<table id=\"results\">\n<thead><tr>")
+	col := 0
+	th := func(name string) {
+		fmt.Fprintf(&buf, "<th onclick=\"sortBy(%d)\">%s</th>", col, html.EscapeString(name))
+		col++
+	}
+	th("test")
+	for _, tc := range tcs {
+		for _, m := range cs.metrics {
+			th(tc.Name + "." + m.name)
+		}
+	}
+	for _, m := range cs.metrics {
+		th(m.name + ".delta_pct")
+	}
+	th("severity")
+	th("status")
+	fmt.Fprintf(&buf, "</tr></thead>\n")
+
+	for _, res := range results {
+		severity := res.severity(limits)
+		class := ""
+		if severity > 0 {
+			class = " class=\"regressed\""
+		}
+		name := html.EscapeString(path.Base(res.Test))
+		fmt.Fprintf(&buf, "<tbody><tr%s><td class=\"test\" data-value=\"%s\" title=\"%s\" onclick=\"toggle(this.parentNode.parentNode)\">%s</td>",
+			class, name, html.EscapeString(res.Note), name)
+		for _, s := range res.Stats {
+			for _, m := range cs.metrics {
+				v := m.value(s)
+				fmt.Fprintf(&buf, "<td data-value=\"%s\">%s</td>", jsNumber(v), m.cell(s))
+			}
+		}
+		for _, m := range cs.metrics {
+			d := deltaPct(m.value(res.Stats[0]), m.value(res.Stats[1]))
+			fmt.Fprintf(&buf, "<td data-value=\"%s\">%.2f</td>", jsNumber(d), d)
+		}
+		fmt.Fprintf(&buf, "<td data-value=\"%s\">%.2f</td>", jsNumber(severity), severity)
+		fmt.Fprintf(&buf, "<td data-value=\"%s\">%s</td></tr>\n", res.status(), res.status())
+
+		fmt.Fprintf(&buf, "<tr class=\"detail\" style=\"display: none\"><td colspan=\"%d\">", col)
+		fmt.Fprintf(&buf, "<b>%s</b><br>\n", html.EscapeString(res.Test))
+		if res.Note != "" {
+			fmt.Fprintf(&buf, "<i>%s</i><br>\n", html.EscapeString(res.Note))
+		}
+		for i, s := range res.Stats {
+			fmt.Fprintf(&buf, "%s:", html.EscapeString(tcs[i].Name))
+			for _, m := range metrics {
+				fmt.Fprintf(&buf, " %s=%s", m.name, m.format(m.value(s)))
+			}
+			fmt.Fprintf(&buf, "<br>\n")
+		}
+		if deltas := functionDeltas(res.Stats, *perFunction); len(deltas) > 0 {
+			fmt.Fprintf(&buf, "<table><tr><th>function</th><th>%s</th><th>%s</th><th>delta</th></tr>\n",
+				html.EscapeString(tcs[0].Name), html.EscapeString(tcs[1].Name))
+			for _, d := range deltas {
+				fmt.Fprintf(&buf, "<tr><td class=\"test\">%s</td><td>%d</td><td>%d</td><td>%+d</td></tr>\n",
+					html.EscapeString(d.Name), d.Counts[0], d.Counts[1], d.delta())
+			}
+			fmt.Fprintf(&buf, "</table>\n")
+		}
+		if len(res.FunctionDiffs) > 0 {
+			fmt.Fprintf(&buf, "<table><tr><th>function</th><th>changed</th><th>%s</th><th>%s</th></tr>\n",
+				html.EscapeString(tcs[0].Name), html.EscapeString(tcs[1].Name))
+			for _, d := range res.FunctionDiffs {
+				fmt.Fprintf(&buf, "<tr><td class=\"test\">%s</td><td>%d</td><td>%d</td><td>%d</td></tr>\n",
+					html.EscapeString(d.Name), d.Changed, d.Counts[0], d.Counts[1])
+			}
+			fmt.Fprintf(&buf, "</table>\n")
+		}
+		if deltas := passDeltas(res.Stats, 10); len(deltas) > 0 {
+			fmt.Fprintf(&buf, "<table><tr><th>pass</th><th>%s wall</th><th>%s wall</th></tr>\n",
+				html.EscapeString(tcs[0].Name), html.EscapeString(tcs[1].Name))
+			for _, d := range deltas {
+				fmt.Fprintf(&buf, "<tr><td class=\"test\">%s</td><td>%v</td><td>%v</td></tr>\n",
+					html.EscapeString(d.Name), d.Times[0], d.Times[1])
+			}
+			fmt.Fprintf(&buf, "</table>\n")
+		}
+		if res.DiffFile != "" {
+			fmt.Fprintf(&buf, "<a href=\"%s\">asm diff</a><br>\n", html.EscapeString(res.DiffFile))
+		}
+		if res.SideBySideFile != "" {
+			fmt.Fprintf(&buf, "<a href=\"%s\">side by side</a><br>\n", html.EscapeString(res.SideBySideFile))
+		}
+		fmt.Fprintf(&buf, "</td></tr></tbody>\n")
+	}
+	fmt.Fprintf(&buf, "</table>\n</body>\n</html>\n")
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+const chartSize = 300
+const chartMargin = 40
+
+var histogramBuckets = []float64{-10, -5, -1, 1, 5, 10}
+
+func writeStatusMatrix(buf *bytes.Buffer, tcs [2]*Toolchain, m [2][2]int) {
+	names := [2]string{html.EscapeString(tcs[0].Name), html.EscapeString(tcs[1].Name)}
+	fmt.Fprintf(buf, "<table class=\"matrix\">\n<tr><th></th><th>%s pass</th><th>%s fail</th></tr>\n", names[1], names[1])
+	for i, outcome := range []string{"pass", "fail"} {
+		fmt.Fprintf(buf, "<tr><th>%s %s</th><td>%d</td><td>%d</td></tr>\n", names[0], outcome, m[i][0], m[i][1])
+	}
+	fmt.Fprintf(buf, "</table>\n")
+}
+
+func writeCharts(buf *bytes.Buffer, tcs [2]*Toolchain, cs *columnSet, results []*Result) {
+	if len(results) == 0 {
+		return
+	}
+	for _, m := range cs.metrics {
+		writeScatter(buf, tcs, m, results)
+		writeHistogram(buf, m, results)
+	}
+	fmt.Fprintf(buf, "<br>\n")
+}
+
+// writeScatter plots the t1 value of the metric against the t2 value, one
+// point per test. Points above the diagonal are tests where t2 is worse.
+func writeScatter(buf *bytes.Buffer, tcs [2]*Toolchain, m metric, results []*Result) {
+	max := 0.0
+	for _, res := range results {
+		for _, st := range res.Stats {
+			if v := m.value(st); v > max {
+				max = v
+			}
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+	scale := func(v float64) float64 { return v / max * chartSize }
+	fmt.Fprintf(buf, "<div class=\"chart\"><b>%s</b><br>\n", html.EscapeString(m.name))
+	fmt.Fprintf(buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n",
+		chartSize+chartMargin, chartSize+chartMargin)
+	fmt.Fprintf(buf, "<g transform=\"translate(%d,%d) scale(1,-1)\">\n", chartMargin, chartSize)
+	fmt.Fprintf(buf, "<rect width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"#999\"/>\n", chartSize, chartSize)
+	fmt.Fprintf(buf, "<line x1=\"0\" y1=\"0\" x2=\"%d\" y2=\"%d\" stroke=\"#999\" stroke-dasharray=\"4\"/>\n",
+		chartSize, chartSize)
+	for _, res := range results {
+		v1, v2 := m.value(res.Stats[0]), m.value(res.Stats[1])
+		color := "#36c"
+		if v2 > v1 {
+			color = "#c33"
+		}
+		fmt.Fprintf(buf, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"2.5\" fill=\"%s\"><title>%s</title></circle>\n",
+			scale(v1), scale(v2), color, html.EscapeString(path.Base(res.Test)))
+	}
+	fmt.Fprintf(buf, "</g>\n")
+	fmt.Fprintf(buf, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\">%s</text>\n",
+		chartMargin+chartSize/2, chartSize+chartMargin-10, html.EscapeString(tcs[0].Name))
+	fmt.Fprintf(buf, "<text x=\"12\" y=\"%d\" text-anchor=\"middle\" transform=\"rotate(-90 12 %d)\">%s</text>\n",
+		chartSize/2, chartSize/2, html.EscapeString(tcs[1].Name))
+	fmt.Fprintf(buf, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\" font-size=\"10\">%s</text>\n",
+		chartMargin+chartSize, chartSize+12, m.format(max))
+	fmt.Fprintf(buf, "</svg></div>\n")
+}
+
+// writeHistogram draws a bar chart of how many tests fall into each bucket
+// of percent delta.
+func writeHistogram(buf *bytes.Buffer, m metric, results []*Result) {
+	counts := make([]int, len(histogramBuckets)+1)
+	for _, res := range results {
+		d := deltaPct(m.value(res.Stats[0]), m.value(res.Stats[1]))
+		i := 0
+		for i < len(histogramBuckets) && d >= histogramBuckets[i] {
+			i++
+		}
+		counts[i]++
+	}
+	max := 1
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	barWidth := chartSize / len(counts)
+	fmt.Fprintf(buf, "<div class=\"chart\"><b>%s delta %%</b><br>\n", html.EscapeString(m.name))
+	fmt.Fprintf(buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n",
+		chartSize+chartMargin, chartSize+chartMargin)
+	for i, c := range counts {
+		h := c * (chartSize - 20) / max
+		x := chartMargin + i*barWidth
+		color := "#999"
+		if i < len(histogramBuckets) && histogramBuckets[i] <= -1 {
+			color = "#36c"
+		} else if i > 0 && histogramBuckets[i-1] >= 1 {
+			color = "#c33"
+		}
+		fmt.Fprintf(buf, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n",
+			x+2, chartSize-h, barWidth-4, h, color)
+		fmt.Fprintf(buf, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\" font-size=\"10\">%d</text>\n",
+			x+barWidth/2, chartSize-h-4, c)
+		fmt.Fprintf(buf, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\" font-size=\"10\">%s</text>\n",
+			x+barWidth/2, chartSize+14, html.EscapeString(bucketLabel(i)))
+	}
+	fmt.Fprintf(buf, "</svg></div>\n")
+}
+
+func bucketLabel(i int) string {
+	switch {
+	case i == 0:
+		return fmt.Sprintf("<%v", histogramBuckets[0])
+	case i == len(histogramBuckets):
+		return fmt.Sprintf(">=%v", histogramBuckets[i-1])
+	}
+	return fmt.Sprintf("%v..%v", histogramBuckets[i-1], histogramBuckets[i])
+}
+
+func writeBuckets(buf *bytes.Buffer, ms []metric, buckets []*sizeBucket) {
+	fmt.Fprintf(buf, "<h3>By input size</h3>\n<table>\n<tr><th>size</th><th>tests</th>")
+	for _, m := range ms {
+		fmt.Fprintf(buf, "<th>%s.delta_pct</th>", html.EscapeString(m.name))
+	}
+	fmt.Fprintf(buf, "</tr>\n")
+	for _, b := range buckets {
+		fmt.Fprintf(buf, "<tr><td>%s</td><td>%d</td>", html.EscapeString(b.label), b.tests)
+		for j := range ms {
+			fmt.Fprintf(buf, "<td>%.2f</td>", deltaPct(b.totals[0][j], b.totals[1][j]))
+		}
+		fmt.Fprintf(buf, "</tr>\n")
+	}
+	fmt.Fprintf(buf, "</table>\n")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// readerErrorRegexp matches the errors of a bitcode reader which is older than
+// the bitcode it is given.
+var readerErrorRegexp = regexp.MustCompile(`[Bb]itcode|[Bb]itstream|Invalid record|Invalid value|` +
+	`Invalid type|Unknown attribute|Malformed block|Unsupported version`)
+
+// inputOverrides replaces the input of a test for one toolchain, e.g. with
+// bitcode downgraded for it. The keys are "<toolchain name>\x00<test>".
+var (
+	inputOverridesMu sync.Mutex
+	inputOverrides   = make(map[string]string)
+)
+
+func overrideInput(tc *Toolchain, test, replacement string) {
+	inputOverridesMu.Lock()
+	defer inputOverridesMu.Unlock()
+	inputOverrides[tc.Name+"\x00"+test] = replacement
+}
+
+func inputFor(tc *Toolchain, test string) string {
+	inputOverridesMu.Lock()
+	defer inputOverridesMu.Unlock()
+	if replacement, ok := inputOverrides[tc.Name+"\x00"+test]; ok {
+		return replacement
+	}
+	return test
+}
+
+// cleanupInputs removes the temporary files made for input overrides.
+func cleanupInputs() {
+	inputOverridesMu.Lock()
+	defer inputOverridesMu.Unlock()
+	for _, replacement := range inputOverrides {
+		os.Remove(replacement)
+	}
+	inputOverrides = make(map[string]string)
+}
+
+// prepareInput returns the bitcode to feed to the toolchain's llc for the
+// test. Textual IR is assembled with the toolchain's own llvm-as, so both
+// toolchains parse the format they are best at; if there is no llvm-as,
+// the IR is passed to llc as is. With -strip-debug, the debug info is
+// removed with the toolchain's opt.
+func prepareInput(tc *Toolchain, test string) (data []byte, err os.Error) {
+	test = inputFor(tc, test)
+	if data, err = ioutil.ReadFile(test); err != nil {
+		return
+	}
+	if filepath.Ext(test) == ".ll" {
+		if _, err = os.Stat(tc.tool("llvm-as")); err == nil {
+			if data, err = tc.run(data, "llvm-as", "-o", "-"); err != nil {
+				return
+			}
+		}
+		err = nil
+	}
+	if *stripDebug {
+		return tc.run(data, "opt", "-strip-debug", "-o", "-")
+	}
+	return
+}
+
+func verifyInput(tc *Toolchain, test string) (err os.Error) {
+	var data []byte
+	if data, err = prepareInput(tc, test); err != nil {
+		return
+	}
+	_, err = tc.run(data, "opt", "-verify", "-disable-output")
+	return
+}
+
+// downgradeInput rewrites the test for the toolchain older by disassembling it
+// with the toolchain newer and assembling the IR with older's llvm-as.
+func downgradeInput(older, newer *Toolchain, test string) (err os.Error) {
+	var data, ir, bc []byte
+	if data, err = prepareInput(newer, test); err != nil {
+		return
+	}
+	if ir, err = newer.run(data, "llvm-dis", "-o", "-"); err != nil {
+		return
+	}
+	if bc, err = older.run(ir, "llvm-as", "-o", "-"); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = ioutil.TempFile("", "llvm-side-by-side-downgraded"); err != nil {
+		return
+	}
+	_, err = f.Write(bc)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	overrideInput(older, test, f.Name())
+	if err = verifyInput(older, test); err != nil {
+		return fmt.Errorf("downgraded bitcode does not verify: %v", err)
+	}
+	return
+}
+
+// verifyInputs checks that both toolchains can read and verify the test. If
+// only one of them fails with a bitcode reader error, the test is too new for
+// it and is reported as INCOMPATIBLE, or downgraded with -downgrade-bitcode.
+// Other failures are reported as PARSE_FAIL. It returns nil if the test can
+// be run.
+func verifyInputs(tcs [2]*Toolchain, test string) *Result {
+	var errs [2]os.Error
+	for i, tc := range tcs {
+		errs[i] = verifyInput(tc, test)
+	}
+	if errs[0] == nil && errs[1] == nil {
+		return nil
+	}
+	res := &Result{Test: test, Statuses: [2]string{statusOK, statusOK}}
+	for i, err := range errs {
+		if err != nil {
+			res.Statuses[i] = statusParseFail
+		}
+	}
+	for i, err := range errs {
+		other := 1 - i
+		if err == nil || errs[other] != nil || !readerErrorRegexp.MatchString(err.String()) {
+			continue
+		}
+		if *downgradeBitcode {
+			derr := downgradeInput(tcs[i], tcs[other], test)
+			if derr == nil {
+				return nil
+			}
+			errs[i] = fmt.Errorf("%v; downgrading failed: %v", err, derr)
+		}
+		res.Statuses[i] = statusIncompatible
+	}
+	var msgs []string
+	res.Errors = make([]*ErrorInfo, 2)
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", tcs[i].Name, err))
+			res.Errors[i] = &ErrorInfo{Phase: "verify", Classification: res.Statuses[i], Message: err.String()}
+		}
+	}
+	res.Err = fmt.Errorf("verifyInputs: %s", strings.Join(msgs, "; "))
+	return res
+}
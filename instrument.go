@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// instrumentMarker prefixes the lines in which an instrumented executable
+// reports its measurements on stderr when it exits.
+const instrumentMarker = "llvm-side-by-side-"
+
+var instrumentRegexp = regexp.MustCompile(instrumentMarker + `([a-z_]+): ([0-9]+)\n`)
+
+// stackRuntime paints the stack below main with a pattern before main
+// runs and, at exit, finds how deep the pattern was overwritten. The
+// painted depth stays within the default 8 MB stack limit.
+const stackRuntime = `
+#include <stdint.h>
+#include <stdio.h>
+
+#define PAINT_BYTES (6 << 20)
+#define PAINT 0xa5
+
+static uintptr_t painted_low, painted_high;
+
+__attribute__((noinline)) static void paint(void) {
+	volatile unsigned char buf[PAINT_BYTES];
+	for (long i = 0; i < PAINT_BYTES; i++)
+		buf[i] = PAINT;
+	painted_low = (uintptr_t)buf;
+	painted_high = (uintptr_t)buf + PAINT_BYTES;
+}
+
+__attribute__((constructor)) static void paint_stack(void) {
+	paint();
+}
+
+__attribute__((destructor)) static void report_stack(void) {
+	volatile unsigned char *p = (volatile unsigned char *)painted_low;
+	while ((uintptr_t)p < painted_high && *p == PAINT)
+		p++;
+	fprintf(stderr, "` + instrumentMarker + `stack_bytes: %lu\n", (unsigned long)(painted_high - (uintptr_t)p));
+}
+`
+
+// heapRuntime wraps the allocation functions which the code of the test
+// calls, linked with --wrap, and reports at exit the peak of the live heap
+// bytes and the number of allocations. The allocations of the libraries,
+// e.g. the stdio buffers, are not counted.
+const heapRuntime = `
+#include <malloc.h>
+#include <stdio.h>
+
+void *__real_malloc(size_t size);
+void *__real_calloc(size_t n, size_t size);
+void *__real_realloc(void *p, size_t size);
+void __real_free(void *p);
+
+static size_t live, peak, allocs;
+
+static void *counted(void *p) {
+	if (p) {
+		allocs++;
+		if ((live += malloc_usable_size(p)) > peak)
+			peak = live;
+	}
+	return p;
+}
+
+void *__wrap_malloc(size_t size) {
+	return counted(__real_malloc(size));
+}
+
+void *__wrap_calloc(size_t n, size_t size) {
+	return counted(__real_calloc(n, size));
+}
+
+void *__wrap_realloc(void *p, size_t size) {
+	size_t old = p ? malloc_usable_size(p) : 0;
+	void *q = __real_realloc(p, size);
+	if (q || size == 0)
+		live -= old;
+	return counted(q);
+}
+
+void __wrap_free(void *p) {
+	if (p)
+		live -= malloc_usable_size(p);
+	__real_free(p);
+}
+
+__attribute__((destructor)) static void report_heap(void) {
+	fprintf(stderr, "` + instrumentMarker + `heap_peak: %lu\n` + instrumentMarker + `heap_allocs: %lu\n",
+		(unsigned long)peak, (unsigned long)allocs);
+}
+`
+
+// instrumentObject compiles a C runtime with the toolchain's clang for
+// the -run-target.
+func instrumentObject(tc *Toolchain, source string) (objFile string, err os.Error) {
+	var f *os.File
+	if f, err = ioutil.TempFile("", "llvm-side-by-side-runtime"); err != nil {
+		return
+	}
+	objFile = f.Name()
+	f.Close()
+	args := append(linkArgs(), "-O2", "-x", "c", "-c", "-o", objFile, "-")
+	if _, err = tc.run([]byte(source), "clang", args...); err != nil {
+		os.Remove(objFile)
+		return "", fmt.Errorf("clang: %v", err)
+	}
+	return
+}
+
+// runInstrumented builds the executable of the assembly with a C runtime
+// and the extra link flags, runs it once as the spec tells, and returns
+// the measurements it reported.
+func runInstrumented(tc *Toolchain, asm string, spec *runSpec, source string,
+	ldflags ...string) (values map[string]int64, err os.Error) {
+	var objFile, exeFile string
+	if objFile, err = instrumentObject(tc, source); err != nil {
+		return
+	}
+	defer os.Remove(objFile)
+	if exeFile, err = buildExecutable(tc, asm, append(ldflags, objFile)...); err != nil {
+		return
+	}
+	defer os.Remove(exeFile)
+	if dev != nil {
+		if exeFile, err = dev.push(exeFile); err != nil {
+			return
+		}
+		defer dev.remove(exeFile)
+	}
+	var out *testOutput
+	if out, err = runExecutable(exeFile, spec); err != nil {
+		return
+	}
+	values = make(map[string]int64)
+	for _, ss := range instrumentRegexp.FindAllStringSubmatch(out.stderr, -1) {
+		if values[ss[1]], err = strconv.Atoi64(ss[2]); err != nil {
+			return nil, err
+		}
+	}
+	if len(values) == 0 {
+		return nil, os.NewError("the executable did not report its measurements, e.g. because it ended with _exit")
+	}
+	return
+}
+
+// measureStack records the peak stack usage of the executable with -run-stack.
+func measureStack(tc *Toolchain, asm string, spec *runSpec, stats *Stats) (err os.Error) {
+	var values map[string]int64
+	if values, err = runInstrumented(tc, asm, spec, stackRuntime); err != nil {
+		return
+	}
+	stats.RunStackBytes = values["stack_bytes"]
+	return
+}
+
+// measureHeap records the peak heap usage and the number of allocations of
+// the executable with -run-heap. It needs an ELF linker for --wrap.
+func measureHeap(tc *Toolchain, asm string, spec *runSpec, stats *Stats) (err os.Error) {
+	var values map[string]int64
+	if values, err = runInstrumented(tc, asm, spec, heapRuntime,
+		"-Wl,--wrap=malloc,--wrap=calloc,--wrap=realloc,--wrap=free"); err != nil {
+		return
+	}
+	stats.RunHeapPeak, stats.RunHeapAllocs = values["heap_peak"], values["heap_allocs"]
+	return
+}
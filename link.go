@@ -0,0 +1,107 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// linkObject assembles the assembly with llvm-mc, links the object into a
+// shared library with the toolchain's ld.lld and records the link time, the
+// size of the output and the statistics lld prints.
+func linkObject(tc *Toolchain, asm string, stats *Stats) (err os.Error) {
+	var objFile string
+	if objFile, err = assemble(tc, asm); err != nil {
+		return
+	}
+	defer os.Remove(objFile)
+	outFile := objFile + ".so"
+	defer os.Remove(outFile)
+	args := append([]string{"-shared", "-o", outFile}, strings.Fields(*linkFlags)...)
+	var out *testOutput
+	if out, err = runTimed(tc.tool("ld.lld"), append(args, objFile), nil); err != nil {
+		return fmt.Errorf("ld.lld: %v", err)
+	}
+	stats.LinkSeconds = out.cpuSeconds
+	stats.LinkWallSeconds = out.wallSeconds
+	var fi *os.FileInfo
+	if fi, err = os.Stat(outFile); err != nil {
+		return
+	}
+	stats.LinkedBytes = fi.Size
+	if *symbolDiff > 0 {
+		if stats.Symbols, err = symbolSizes(outFile); err != nil {
+			return fmt.Errorf("symbolSizes: %v", err)
+		}
+	}
+	for _, line := range strings.Split(out.stderr, "\n") {
+		if ss := statRegexp.FindStringSubmatch(strings.TrimSpace(line)); len(ss) == 4 {
+			if v, err := strconv.Atoi(ss[1]); err == nil {
+				stats.Counters["lld: "+ss[2]+" - "+ss[3]] += v
+			}
+		}
+	}
+	return
+}
+
+// symbolSizes returns the sizes of the functions and data objects defined in
+// an ELF binary.
+func symbolSizes(filename string) (sizes map[string]int, err os.Error) {
+	var f *elf.File
+	if f, err = elf.Open(filename); err != nil {
+		return
+	}
+	defer f.Close()
+	var syms []elf.Symbol
+	if syms, err = f.Symbols(); err != nil {
+		return
+	}
+	sizes = make(map[string]int)
+	for _, sym := range syms {
+		t := elf.ST_TYPE(sym.Info)
+		if sym.Section == elf.SHN_UNDEF || sym.Size == 0 || (t != elf.STT_FUNC && t != elf.STT_OBJECT) {
+			continue
+		}
+		sizes[sym.Name] += int(sym.Size)
+	}
+	return
+}
+
+// printSymbolDiffs prints, for each test whose linked binaries differ in
+// their symbols, how many symbols grew, shrank, were added and were removed,
+// and the -symbol-diff symbols whose sizes changed the most.
+func printSymbolDiffs(tcs [2]*Toolchain, results []*Result) {
+	for _, res := range results {
+		m := [2]map[string]int{res.Stats[0].Symbols, res.Stats[1].Symbols}
+		deltas := countDeltas(m, len(m[0])+len(m[1]))
+		if len(deltas) == 0 {
+			continue
+		}
+		var grown, shrunk, added, removed, total int
+		for _, d := range deltas {
+			switch {
+			case d.Counts[0] == 0:
+				added++
+			case d.Counts[1] == 0:
+				removed++
+			case d.delta() > 0:
+				grown++
+			default:
+				shrunk++
+			}
+			total += d.delta()
+		}
+		fmt.Printf("\nSymbol size changes in %s: %+d bytes, %d grown, %d shrunk, %d added, %d removed\n",
+			path.Base(res.Test), total, grown, shrunk, added, removed)
+		fmt.Printf("symbol\t%s\t%s\tdelta\n", tcs[0].Name, tcs[1].Name)
+		if len(deltas) > *symbolDiff {
+			deltas = deltas[:*symbolDiff]
+		}
+		for _, d := range deltas {
+			fmt.Printf("%s\t%d\t%d\t%+d\n", d.Name, d.Counts[0], d.Counts[1], d.delta())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// logPolicies are the values of -keep-logs.
+var logPolicies = map[string]bool{"none": true, "divergent": true, "all": true}
+
+// capLog shortens a log to -log-limit bytes, keeping its head and its tail,
+// where compilers print the errors.
+func capLog(data string) string {
+	if *logLimit <= 0 || len(data) <= *logLimit {
+		return data
+	}
+	half := *logLimit / 2
+	return fmt.Sprintf("%s\n[... %d bytes dropped by -log-limit ...]\n%s", data[:half], len(data)-2*half,
+		data[len(data)-half:])
+}
+
+// writeLog saves one stream of a compiler run of the test to the
+// -artifact-dir, unless it is empty.
+func writeLog(test, tcName, stream, data string) (err os.Error) {
+	if data == "" {
+		return
+	}
+	if err = os.MkdirAll(*artifactDir, 0755); err != nil {
+		return
+	}
+	return ioutil.WriteFile(artifactPath(test, "."+tcName+"."+stream+".log"), []byte(capLog(data)), 0644)
+}
+
+// keepLogs saves the compiler output of both toolchains for a test which
+// ran, with -keep-logs=all or if the test diverged.
+func keepLogs(tcs [2]*Toolchain, res *Result) {
+	if *artifactDir == "" || *keepLogsFlag == "none" {
+		return
+	}
+	if *keepLogsFlag == "divergent" && res.Identical && !res.diverged() {
+		return
+	}
+	for i, s := range res.Stats {
+		for _, stream := range []struct{ name, data string }{{"stdout", s.stdout}, {"stderr", s.stderr}} {
+			if err := writeLog(res.Test, tcs[i].Name, stream.name, stream.data); err != nil {
+				log.Printf("keepLogs(%s): %v", res.Test, err)
+			}
+		}
+	}
+}
+
+// keepFailureLogs saves the stderr of the toolchains which failed on the
+// test, unless -keep-logs=none.
+func keepFailureLogs(test string, errs [2]os.Error) {
+	if *artifactDir == "" || *keepLogsFlag == "none" {
+		return
+	}
+	for _, err := range errs {
+		te, ok := err.(*testError)
+		if !ok {
+			continue
+		}
+		stderr := ""
+		switch e := te.err.(type) {
+		case *llcFailure:
+			stderr = e.stderr
+		case *runtimeCrash:
+			stderr = e.stderr
+		}
+		if err := writeLog(test, te.tc.Name, "stderr", stderr); err != nil {
+			log.Printf("keepFailureLogs(%s): %v", test, err)
+		}
+	}
+}
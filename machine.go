@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// timingWarningLabel is the run label which tells why the timings of the run
+// may be unstable.
+const timingWarningLabel = "timing_warning"
+
+func readSysfs(filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// cpuScalingProblems lists what makes the timings unstable on this machine:
+// CPUs whose cpufreq governor is not "performance" and enabled turbo boost.
+// It only knows the Linux sysfs files and finds nothing elsewhere.
+func cpuScalingProblems() (problems []string) {
+	files, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	governors := make(map[string]int)
+	for _, f := range files {
+		if g := readSysfs(f); g != "" && g != "performance" {
+			governors[g]++
+		}
+	}
+	var names []string
+	for g := range governors {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	for _, g := range names {
+		problems = append(problems, fmt.Sprintf("%d of %d CPUs use the %s governor", governors[g], len(files), g))
+	}
+	if readSysfs("/sys/devices/system/cpu/intel_pstate/no_turbo") == "0" ||
+		readSysfs("/sys/devices/system/cpu/cpufreq/boost") == "1" {
+		problems = append(problems, "turbo boost is enabled")
+	}
+	return
+}
+
+// checkCPUScaling warns about the CPU frequency scaling before the timed
+// runs and labels the run with it, or refuses to run with
+// -require-performance-governor.
+func checkCPUScaling() os.Error {
+	problems := cpuScalingProblems()
+	if len(problems) == 0 {
+		return nil
+	}
+	if *requirePerformanceGovernor {
+		return fmt.Errorf("The CPU frequency scaling makes the timings unstable: %s", strings.Join(problems, "; "))
+	}
+	log.Printf("Warning: the CPU frequency scaling makes the timings unstable: %s", strings.Join(problems, "; "))
+	labels[timingWarningLabel] = strings.Join(problems, "; ")
+	return nil
+}
+
+// parseCPUList parses a kernel CPU list such as "2-3,6".
+func parseCPUList(list string) (cpus []int, err os.Error) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		bounds := strings.SplitN(item, "-", 2)
+		var first, last int
+		if first, err = strconv.Atoi(bounds[0]); err != nil {
+			return nil, fmt.Errorf("CPU list %q: %v", list, err)
+		}
+		last = first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("CPU list %q: %v", list, err)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return
+}
+
+// kernelIsolatedCPUs returns the CPUs isolated with isolcpus or nohz_full.
+func kernelIsolatedCPUs() (cpus []int) {
+	seen := make(map[int]bool)
+	for _, f := range []string{"/sys/devices/system/cpu/isolated", "/sys/devices/system/cpu/nohz_full"} {
+		list, err := parseCPUList(readSysfs(f))
+		if err != nil {
+			continue
+		}
+		for _, cpu := range list {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	sort.Ints(cpus)
+	return
+}
+
+// busyOn lists the running threads of other processes on the CPUs, from
+// the last CPU /proc/<pid>/task/<tid>/stat reports for them.
+func busyOn(cpus []int) (busy []string) {
+	want := make(map[int]bool)
+	for _, cpu := range cpus {
+		want[cpu] = true
+	}
+	self := fmt.Sprintf("/proc/%d/", os.Getpid())
+	files, _ := filepath.Glob("/proc/[0-9]*/task/[0-9]*/stat")
+	for _, f := range files {
+		if strings.HasPrefix(f, self) {
+			continue
+		}
+		stat := readSysfs(f)
+		i := strings.LastIndex(stat, ")")
+		if i < 0 {
+			continue
+		}
+		// The fields after the command name start with the state, the third
+		// field of the file; the CPU is the 39th.
+		fields := strings.Fields(stat[i+1:])
+		if len(fields) < 37 || fields[0] != "R" {
+			continue
+		}
+		if cpu, err := strconv.Atoi(fields[36]); err == nil && want[cpu] {
+			busy = append(busy, fmt.Sprintf("%s (CPU %d)", stat[:i+1], cpu))
+		}
+	}
+	return
+}
+
+// cpuPool hands out the -isolated-cpus to the timed processes, one CPU per
+// process at a time. It is nil without -isolated-cpus.
+var cpuPool chan int
+
+// setupIsolatedCPUs fills cpuPool from -isolated-cpus, which is a CPU list
+// or "auto" for the CPUs the kernel isolates.
+func setupIsolatedCPUs() (err os.Error) {
+	if *isolatedCPUs == "" || cpuPool != nil {
+		return
+	}
+	if runtime.GOOS != "linux" {
+		return os.NewError("-isolated-cpus is only supported on Linux")
+	}
+	var cpus []int
+	if *isolatedCPUs == "auto" {
+		if cpus = kernelIsolatedCPUs(); len(cpus) == 0 {
+			return os.NewError("-isolated-cpus=auto: the kernel isolates no CPUs, boot it with isolcpus= or nohz_full=")
+		}
+	} else {
+		if cpus, err = parseCPUList(*isolatedCPUs); err != nil {
+			return fmt.Errorf("parseCPUList: %v", err)
+		}
+		isolated := make(map[int]bool)
+		for _, cpu := range kernelIsolatedCPUs() {
+			isolated[cpu] = true
+		}
+		for _, cpu := range cpus {
+			if !isolated[cpu] {
+				log.Printf("Warning: CPU %d is not isolated with isolcpus= or nohz_full=", cpu)
+			}
+		}
+	}
+	if busy := busyOn(cpus); len(busy) > 0 {
+		return fmt.Errorf("Other processes run on the -isolated-cpus: %s", strings.Join(busy, ", "))
+	}
+	if *jobs > len(cpus) {
+		log.Printf("Warning: -j %d is more than the %d isolated CPUs, the tests will wait for them", *jobs, len(cpus))
+	}
+	cpuPool = make(chan int, len(cpus))
+	for _, cpu := range cpus {
+		cpuPool <- cpu
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"exec"
 	"flag"
@@ -13,35 +14,325 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
-	t1 = flag.String("t1", "", "Path to the first toolchain")
-	t2 = flag.String("t2", "", "Path to the second toolchain")
-	test = flag.String("test", "", "Path to the test bitcode file")
+	t1 = flag.String("t1", "", "Path to the first toolchain, PATH for the tools on $PATH, or "+
+		"name=<label>,path=<path>[,driver=llc|clang|gcc|rustc][,sha256=<sum of the compiler>]"+
+		"[,suffix=<tool name suffix>][,tool.<tool>=<binary>]")
+	t2 = flag.String("t2", "", "Path to the second toolchain, PATH for the tools on $PATH, or "+
+		"name=<label>,path=<path>[,driver=llc|clang|gcc|rustc][,sha256=<sum of the compiler>]"+
+		"[,suffix=<tool name suffix>][,tool.<tool>=<binary>]")
+	test = flag.String("test", "", "Path to the test bitcode or .ll file, a .tar, .tar.gz or .zip of tests, "+
+		"an http(s):// or gs:// URL of one of those, or - to read newline-separated paths from stdin")
+	onlyRegressions = flag.Bool("only-regressions", false, "Print only the tests which regressed beyond the thresholds, worst first")
+	thresholds = flag.String("thresholds", "asm_instrs=0,stack=0,seconds=5,wall=5",
+		"Comma-separated list of metric=percent regression thresholds")
+	columns = flag.String("columns", "asm_instrs,stack,seconds,wall",
+		"Comma-separated list of metrics to print; add delta and/or delta_pct to also print their differences, "+
+		"and status to print whether the codegen is identical")
+	sortBy = flag.String("sort", "", "Order the results by the percent delta of a metric, as metric[:asc|desc]")
+	htmlOut = flag.String("html", "", "Write an HTML report to this file")
+	resultsOut = flag.String("out", "", "Write machine-readable results to this .csv or .json file")
+	appendResults = flag.Bool("append", false, "Append to the -out file instead of overwriting it")
+	reportTemplate = flag.String("template", "", "Write a report with this Go template file, "+
+		"executed with the run, the metrics and the results")
+	templateOut = flag.String("template-out", "", "Write the -template report to this file instead of stdout")
+	bundleOut = flag.String("bundle", "", "Pack the run metadata, the results, the reports, the artifacts "+
+		"and the log into this .tar.gz file")
+	skipFile = flag.String("skip", "", "File of tests not to run, one \"<test>: <reason>\" per line; triage adds to it")
+	xfailFile = flag.String("xfail", "", "File of tests whose divergence is expected, one \"<test>: <reason>\" per line; "+
+		"it does not count for -exit-on and triage adds to it")
+	notesFile = flag.String("notes", "", "File with per-test notes, one \"<test>: <note>\" per line")
+	diagnosticsLimit = flag.Int("diagnostics", 10, "Report this many of the compiler diagnostics which only "+
+		"one toolchain printed in a test, by the number of tests; 0 disables it")
+	tuiFlag = flag.Bool("tui", false, "Follow the results in a terminal table which updates as the tests finish; "+
+		"the arrow keys select a test, enter shows its details and asm diff and q quits")
+	perFunction = flag.Int("per-function", 0, "Report this many functions with the biggest instruction count changes per test")
+	padding = flag.Bool("padding", false, "Assemble the output with llvm-mc and measure the alignment padding in .text")
+	instrBytes = flag.Bool("instr-bytes", false, "Sum the encoded sizes of the instructions with llvm-mc "+
+		"-show-encoding; -padding does it too")
+	link = flag.Bool("link", false, "Link the assembled output into a shared library with each toolchain's "+
+		"ld.lld and compare the link time and the output size")
+	linkFlags = flag.String("link-args", "", "Extra space-separated flags to pass to ld.lld with -link")
+	symbolDiff = flag.Int("symbol-diff", 0, "With -link, report the symbols of the linked binaries which grew, "+
+		"shrank, were added or removed, listing this many with the biggest size changes per test")
+	runBinaries = flag.Bool("run", false, "Link the output into an executable with each toolchain's clang, "+
+		"run it and compare the median run time")
+	runIterations = flag.Int("run-iterations", 5, "Number of timed runs of each executable with -run")
+	runWarmup = flag.Int("run-warmup", 1, "Number of runs of each executable to discard before the timed ones")
+	perfCounters = flag.Bool("perf", false, "With -run, also compare the cycles, instructions, IPC, branch misses "+
+		"and L1 data cache misses of the executables, counted with perf stat")
+	runTarget = flag.String("run-target", "", "Target triple to link the -run executables for; "+
+		"the executables for another architecture than the host's run under qemu-user")
+	qemuBin = flag.String("qemu", "", "qemu-user binary to run the executables of other architectures with; "+
+		"by default qemu-<arch>")
+	sysroot = flag.String("sysroot", "", "Sysroot to link the -run executables against and to pass to qemu-user as -L")
+	deviceSpec = flag.String("device", "", "Run the -run executables on a device instead: adb, adb:<serial> "+
+		"or ssh:[<user>@]<host>; they are timed on the device")
+	runManifestFile = flag.String("run-manifest", "", "JSON file with the arguments, KEY=value environment "+
+		"variables and stdin file to run the executable of each test with, e.g. "+
+		"{\"rand.ll\": {\"args\": [\"-n\", \"100\"], \"env\": [\"SEED=1\"], \"stdin\": \"rand.in\"}}")
+	runStack = flag.Bool("run-stack", false, "With -run, also compare the peak stack usage of the executables, "+
+		"measured by painting the stack of an extra run of each")
+	runHeap = flag.Bool("run-heap", false, "With -run, also compare the peak heap usage and the number of "+
+		"allocations of the executables, counted by wrapping malloc in an extra run of each")
+	checkOutput = flag.Bool("check-output", false, "With -run, also compare what the executables print; "+
+		"the numbers may differ by -output-abs-epsilon or -output-rel-epsilon")
+	outputAbsEpsilon = flag.Float64("output-abs-epsilon", 0, "Largest absolute difference between two numbers "+
+		"in the outputs of -check-output which still counts as equal")
+	outputRelEpsilon = flag.Float64("output-rel-epsilon", 0, "Largest difference between two numbers in the "+
+		"outputs of -check-output, relative to the larger one, which still counts as equal")
+	tolerancesFile = flag.String("output-tolerances", "", "File with per-test epsilons for -check-output, "+
+		"one \"<test>: abs=<eps>,rel=<eps>\" per line")
+	startup = flag.Bool("startup", false, "With -run, also compare the startup latency of the executables: "+
+		"the time from starting them to their first output, or to their exit if they print nothing")
+	counterAliasesFile = flag.String("counter-aliases", "", "File with one \"<from> => <to>\" alias per line, "+
+		"renaming -stats counters or passes so that counters renamed between LLVM releases are compared")
+	calibrateRuns = flag.Int("calibrate", 0, "Compile an empty module this many times with each toolchain "+
+		"and report the fastest time as its invocation overhead; 0 disables it")
+	subtractOverheadFlag = flag.Bool("subtract-overhead", false, "Subtract the -calibrate overhead from the "+
+		"compile times of every test, so that small tests do not compare process startup")
+	thermalInterval = flag.Int("thermal-interval", 10, "Sample the CPU frequency, temperature and throttling "+
+		"every this many seconds and flag the tests which ran while the CPUs were throttled; 0 disables it")
+	isolatedCPUs = flag.String("isolated-cpus", "", "Run every timed process alone on one of these CPUs, "+
+		"given as a list like 2-3,6 or auto for the isolcpus/nohz_full CPUs; fails if other processes run on them")
+	unquarantineFlag = flag.Bool("unquarantine", false, "On macOS, remove the quarantine attribute from "+
+		"downloaded toolchains instead of refusing to run them")
+	requirePerformanceGovernor = flag.Bool("require-performance-governor", false, "Refuse to run unless every "+
+		"CPU uses the performance cpufreq governor and turbo boost is disabled")
+	sectionSizesFlag = flag.Bool("section-sizes", false, "Assemble the output with llvm-mc and report the size "+
+		"and content changes of every section, e.g. .eh_frame and .rodata")
+	unwind = flag.Bool("unwind", false, "Assemble the output with llvm-mc and compare the size of the unwind "+
+		"and exception tables, such as .eh_frame and .ARM.exidx, and the number of CFI instructions")
+	mcCounters = flag.Bool("mc-stats", false, "Assemble the output with llvm-mc -stats and compare the fixups "+
+		"evaluated and the fragments relaxed")
+	compressedSize = flag.Bool("compressed-size", false, "Measure the gzip-compressed size of the assembly, "+
+		"and of the object with -padding")
+	skipIdentical = flag.Bool("skip-identical", false, "Do not time or analyze tests whose normalized "+
+		"assembly is identical for both toolchains")
+	repeat = flag.Int("repeat", 1, "Time each test this many times and keep the fastest run")
+	twoPhase = flag.Bool("two-phase", false, "Run all tests once without timing first, then measure only "+
+		"the tests whose metrics or assembly differ")
+	artifactDir = flag.String("artifact-dir", "", "Save the assembly and asm diffs of differing tests in this directory")
+	keepLogsFlag = flag.String("keep-logs", "divergent", "Which compiler stdout and stderr logs to save in "+
+		"-artifact-dir: divergent for the failed and diverging tests, all, or none")
+	logLimit = flag.Int("log-limit", 1<<20, "Largest size of a saved log in bytes; longer logs keep their head "+
+		"and tail; 0 means no limit")
+	downloadCache = flag.String("download-cache", path.Join(os.TempDir(), "llvm-side-by-side-downloads"),
+		"Directory for the tests given as http(s):// or gs:// URLs, cached by their -checksums sum or "+
+		"revalidated with the server")
+	toolchainCache = flag.String("toolchain-cache", path.Join(os.TempDir(), "llvm-side-by-side-toolchains"),
+		"Directory with the toolchains of the commit:<hash> toolchain specs, one per commit")
+	toolchainURL = flag.String("toolchain-url", "", "URL of the tarball of a commit's toolchain, "+
+		"with {commit} standing for the commit, to download the toolchains missing from -toolchain-cache")
+	llvmSrc = flag.String("llvm-src", "", "LLVM git checkout to build the toolchains missing from "+
+		"-toolchain-cache with cmake and ninja")
+	checksumsFile = flag.String("checksums", "", "File with the SHA256 sums of remote tests, as printed by "+
+		"sha256sum with their URLs; a download which does not match stops the run")
+	incremental = flag.String("incremental", "", "Cache results in this file and only re-run tests "+
+		"whose contents or toolchain binaries changed")
+	jobs = flag.Int("j", 1, "Number of tests to run in parallel")
+	maxMem = flag.Int("max-mem", 0, "Memory budget for the parallel llc processes, in megabytes; 0 means unlimited")
+	sizeBuckets = flag.String("size-buckets", "", "Comma-separated input size bin edges, e.g. 100k,1M, "+
+		"to report aggregate deltas per size bucket")
+	verify = flag.Bool("verify-inputs", false, "Check every test with opt -verify from both toolchains before "+
+		"running it, and report PARSE_FAIL for the toolchains which can not read it")
+	downgradeBitcode = flag.Bool("downgrade-bitcode", false, "With -verify-inputs, rewrite bitcode which is too "+
+		"new for one toolchain with llvm-dis from the other one and its own llvm-as")
+	stripDebug = flag.Bool("strip-debug", false, "Strip the debug info from the tests with opt -strip-debug before running llc")
+	reduceDir = flag.String("reduce-dir", "", "Reduce the tests which crash only one toolchain or whose metrics diverge "+
+		"with llvm-reduce, and save the reproducers in this directory")
+	categories = flag.Bool("categories", false, "Report the instruction count deltas by category "+
+		"(moves, memory ops, branches, vector ops) for the tests whose assembly differs")
+	functionDiff = flag.Bool("function-diff", false, "Report which functions differ between the assemblies "+
+		"and how many of their instructions changed")
+	goldenDir = flag.String("golden-dir", "", "Compare the normalized assembly of both toolchains with "+
+		"the snapshots in this directory")
+	goldenRoot = flag.String("golden-root", "", "Directory of the corpus, whose layout the -golden-dir snapshots "+
+		"mirror; the current directory by default")
+	updateGolden = flag.Bool("update-golden", false, "Replace the -golden-dir snapshots with the assembly of the first toolchain")
+	difftool = flag.String("difftool", "", "After the run, offer to open the assemblies of the differing tests "+
+		"with this command, e.g. meld; {1} and {2} stand for the files")
+	disasm = flag.String("disasm", "", "Also compare the llvm-objdump disassembly of the assembled output "+
+		"(also), or compare it instead of the assembly (instead), to catch differences in encodings and relaxation")
+	pipelineFile = flag.String("pipeline", "", "File with the stages to run instead of llc, one "+
+		"\"<name>: <tool> <args>\" per line, the tools being taken from each toolchain and {in} and {out} "+
+		"standing for the input and output files; the output of the last stage named *.s is compared")
+	stageCache = flag.String("stage-cache", "", "Keep the outputs of the -pipeline stages in this directory "+
+		"instead of -cas and reuse them when a stage runs again with the same tool binary on the same input")
+	casDir = flag.String("cas", "", "Keep the llc outputs, the assembled objects and the pipeline stage outputs "+
+		"in this directory, keyed by the tool binary, its flags and the input, and reuse them in later runs "+
+		"of any subcommand; a reused output keeps the timings of the run which stored it")
+	llcFlags = flag.String("llc-args", "", "Extra space-separated flags to pass to llc")
+	ccFlags = flag.String("cc-args", "-O2", "Space-separated flags to pass to clang and gcc, "+
+		"for the toolchains with driver=clang or driver=gcc")
+	rustcFlags = flag.String("rustc-args", "-C opt-level=2 --crate-type=lib", "Space-separated flags to pass "+
+		"to rustc, for the toolchains with driver=rustc")
+	timeout = flag.Int("timeout", 0, "Kill llc after this many seconds and report the test as TIMEOUT; 0 means no limit")
+	crashOnly = flag.Bool("crash-only", false, "Only check whether the tests pass, crash or time out with each "+
+		"toolchain, without collecting statistics, and list the tests which crash in exactly one of them")
+	failFast = flag.Bool("fail-fast", false, "Stop at the first failed or regressed test and print "+
+		"what went wrong with it")
+	maxFailures = flag.Int("max-failures", 0, "Stop the run once this many tests failed, crashed or timed out, "+
+		"and report the tests which ran; 0 means no limit")
+	dedup = flag.Bool("dedup", true, "Run the tests with identical contents once and report the others "+
+		"as its aliases")
+	maxVersionSkew = flag.Int("max-version-skew", 1, "Refuse to compare toolchains whose LLVM major versions "+
+		"differ by more than this")
+	allowVersionSkew = flag.Bool("allow-version-skew", false, "Only warn when the toolchains exceed -max-version-skew")
+	exitOn = flag.String("exit-on", "failure", "What makes the exit code non-zero: failure, regression "+
+		"(failures or regressions), divergence (failures, regressions or any differing metric) or never")
+	preTestCmd = flag.String("pre-test-hook", "", "Shell command run before each test with its path as $1; "+
+		"it may print a JSON object with \"veto\" set to skip the test")
+	postCompileCmd = flag.String("post-compile-hook", "", "Shell command run after llc with the toolchain name, "+
+		"the test and the assembly file as $1, $2 and $3; it may print a JSON object with \"metrics\" to add "+
+		"as hook.<name> or \"veto\" to drop the test")
+	postCompareCmd = flag.String("post-compare-hook", "", "Shell command run after both toolchains measured a test "+
+		"with the test and both assembly files as $1, $2 and $3; it may print a JSON object with \"metrics\", "+
+		"\"toolchain_metrics\" (one object per toolchain) or \"veto\"")
 
-	stackSpaceRegexp = regexp.MustCompile(`([0-9]+) pei[^N]+Number of bytes used for stack in all functions`)
-	asmInstrsRegexp = regexp.MustCompile(`([0-9]+) asm-printer[^N]+Number of machine instrs printed`)
-	execTimeRegexp = regexp.MustCompile(`Total Execution Time: ([0-9.]+) seconds \(([0-9.]+) wall clock\)`)
+	statRegexp = regexp.MustCompile(`^([0-9]+) +([^ ]+) +- +(.+)$`)
+	// Newer releases may print an instruction count column after the
+	// times.
+	passTimeRegexp = regexp.MustCompile(`^((?:[0-9.]+ +\( *[0-9.]+%\) +)+)(?:[0-9]+ +)*(.+)$`)
+	passTimeValueRegexp = regexp.MustCompile(`([0-9.]+) +\(`)
+	execTimeRegexp = regexp.MustCompile(`Total Execution Time: +([0-9.]+) +seconds(?: +\(([0-9.]+) +wall clock\))?`)
 )
 
 type Stats struct {
-	AsmInstrs int
-	StackSpace int
+	AsmInstrs int `json:"asm_instrs"`
+	StackSpace int `json:"stack"`
+	// The jump tables and constant pool entries of the assembly, and the
+	// bytes of data in them.
+	JumpTables       int `json:"jump_tables,omitempty"`
+	JumpTableBytes   int `json:"jump_table_bytes,omitempty"`
+	ConstPoolEntries int `json:"const_pool_entries,omitempty"`
+	ConstPoolBytes   int `json:"const_pool_bytes,omitempty"`
 
-	Seconds float64
-	WallSeconds float64
+	Seconds float64 `json:"seconds"`
+	WallSeconds float64 `json:"wall"`
+	// ProcessWallSeconds is the wall time of the compiler process, measured
+	// with the monotonic clock around its start and exit.
+	ProcessWallSeconds float64 `json:"process_wall,omitempty"`
+	MaxRSS int64 `json:"max_rss_kb,omitempty"`
+
+	AsmBytes int `json:"asm_bytes"`
+	AsmLines int `json:"asm_lines"`
+	AsmHash  string `json:"asm_hash,omitempty"`
+	// DisasmHash is the hash of the llvm-objdump output with -disasm=also.
+	DisasmHash string `json:"disasm_hash,omitempty"`
+	Asm      string `json:"-"`
+	// Golden tells how the assembly compares with the -golden-dir snapshot.
+	Golden string `json:"golden,omitempty"`
+
+	AsmGzipBytes int `json:"asm_gzip_bytes,omitempty"`
+	ObjGzipBytes int `json:"obj_gzip_bytes,omitempty"`
+
+	TextBytes    int `json:"text_bytes,omitempty"`
+	InstrBytes   int `json:"instr_bytes,omitempty"`
+	PaddingBytes int `json:"padding_bytes,omitempty"`
+
+	// The link stage with -link.
+	LinkSeconds     float64 `json:"link_seconds,omitempty"`
+	LinkWallSeconds float64 `json:"link_wall,omitempty"`
+	LinkedBytes     int64   `json:"linked_bytes,omitempty"`
+	// The unwind tables with -unwind.
+	UnwindBytes int `json:"unwind_bytes,omitempty"`
+	CFIInstrs   int `json:"cfi_instrs,omitempty"`
+	// Sections maps the sections of the assembled output to their sizes,
+	// and SectionHashes to the hashes of their contents, with -section-sizes.
+	Sections      map[string]int    `json:"sections,omitempty"`
+	SectionHashes map[string]string `json:"section_hashes,omitempty"`
+	// Symbols maps the symbols of the linked binary to their sizes, with
+	// -symbol-diff.
+	Symbols map[string]int `json:"symbols,omitempty"`
+
+	// The executable's run time with -run: the median and minimum wall time
+	// of the counted iterations, and the median CPU time.
+	RunSeconds    float64   `json:"run_seconds,omitempty"`
+	RunMinSeconds float64   `json:"run_min,omitempty"`
+	RunCPUSeconds float64   `json:"run_cpu,omitempty"`
+	RunTimes      []float64 `json:"run_times,omitempty"`
+	// RunOutput is what the executable printed with -check-output.
+	RunOutput *string `json:"run_output,omitempty"`
+	// RunStackBytes is the peak stack usage of the executable with -run-stack.
+	RunStackBytes int64 `json:"run_stack,omitempty"`
+	// The peak live heap bytes and the allocations with -run-heap.
+	RunHeapPeak   int64 `json:"heap_peak,omitempty"`
+	RunHeapAllocs int64 `json:"heap_allocs,omitempty"`
+	// StartupSeconds is the median time to the first output with -startup.
+	StartupSeconds float64 `json:"startup,omitempty"`
+	// The hardware counters of a run with -perf.
+	Cycles       float64 `json:"cycles,omitempty"`
+	Instructions float64 `json:"instructions,omitempty"`
+	BranchMisses float64 `json:"branch_misses,omitempty"`
+	L1DMisses    float64 `json:"l1d_misses,omitempty"`
+
+	Functions map[string]int `json:"functions,omitempty"`
+	// PassTimes maps pass names to their wall time from --time-passes.
+	PassTimes map[string]float64 `json:"pass_times,omitempty"`
+	// Counters holds every -stats line, keyed by "<pass> - <description>".
+	Counters map[string]int `json:"counters,omitempty"`
+	// Categories counts the instructions by kind: moves, memory ops, etc.
+	Categories map[string]int `json:"categories,omitempty"`
+	// Extra holds the values of the -collector metrics.
+	Extra map[string]float64 `json:"extra,omitempty"`
+	// Missing lists the metrics whose counters the toolchain printed in no
+	// test of the run, or not in this test because it printed no counters at
+	// all, although the other one did; they are reported as N/A rather than 0.
+	Missing []string `json:"missing,omitempty"`
+	// Diagnostics holds the distinct warnings, remarks and errors the
+	// compiler printed, without their locations.
+	Diagnostics []string `json:"diagnostics,omitempty"`
+	// stdout and stderr are what the compiler printed, for -keep-logs.
+	stdout, stderr string
 }
 
-func runTest(toolchain, test string) (stderr string, err os.Error) {
-	cmd := exec.Command(path.Join(toolchain, "bin/llc"), "-O0", "-stats", "--time-passes", 
-		"-relocation-model=pic", "-O0", "-asm-verbose=false")
-	var data []byte
-	if data, err = ioutil.ReadFile(test); err != nil {
-		return
+type testOutput struct {
+	stdout string
+	stderr string
+	maxRSS int64 // in kilobytes
+	// cpuSeconds and wallSeconds are measured from outside the compiler.
+	cpuSeconds  float64
+	wallSeconds float64
+}
+
+var llcArgs = []string{"-O0", "-relocation-model=pic", "-O0", "-asm-verbose=false"}
+
+func runTest(tc *Toolchain, input []byte, mode runMode) (out *testOutput, err os.Error) {
+	args := append([]string(nil), llcArgs...)
+	args = append(args, strings.Fields(*llcFlags)...)
+	if mode.stats {
+		args = append(args, "-stats")
+	}
+	if mode.timePasses {
+		args = append(args, "--time-passes")
 	}
-	cmd.Stdin = bytes.NewBuffer(data)
-	var outPipe, errPipe io.Reader
+	return runCached(tc.tool("llc"), tc.adaptFlags(args), input)
+}
+
+// pinnedCommand returns the command to run a timed process, which with
+// -isolated-cpus holds one of them until release is called.
+func pinnedCommand(compiler string, args []string) (cmd *exec.Cmd, release func()) {
+	if cpuPool == nil {
+		return exec.Command(compiler, args...), func() {}
+	}
+	// taskset pins the process before it execs the compiler.
+	cpu := <-cpuPool
+	args = append([]string{"-c", strconv.Itoa(cpu), compiler}, args...)
+	return exec.Command("taskset", args...), func() { cpuPool <- cpu }
+}
+
+// runTimed runs a compiler with the input on stdin, killing it after
+// -timeout, and measures its resource usage. With -isolated-cpus, it runs
+// alone on one of them.
+func runTimed(compiler string, args []string, input []byte) (out *testOutput, err os.Error) {
+	cmd, release := pinnedCommand(compiler, args)
+	defer release()
+	cmd.Stdin = bytes.NewBuffer(input)
+	var outPipe, errPipe io.ReadCloser
 
 	if errPipe, err = cmd.StderrPipe(); err != nil {
 		return
@@ -49,100 +340,266 @@ func runTest(toolchain, test string) (stderr string, err os.Error) {
 	if outPipe, err = cmd.StdoutPipe(); err != nil {
 		return
 	}
+	start := monotonicNanoseconds()
 	if err = cmd.Start(); err != nil {
-		return "", fmt.Errorf("cmd.Start: %v", err)
+		return nil, fmt.Errorf("cmd.Start: %v", err)
 	}
-	if _, err = ioutil.ReadAll(outPipe); err != nil {
-		return "", fmt.Errorf("ioutil.ReadAll(outPipe): %v", err)
+	// The timer tells that it killed llc over a channel, since it runs on
+	// another goroutine.
+	expired := make(chan bool, 1)
+	if *timeout > 0 {
+		timer := time.AfterFunc(int64(*timeout)*1e9, func() {
+			expired <- true
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+	var stdoutData []byte
+	if stdoutData, err = ioutil.ReadAll(outPipe); err != nil {
+		return nil, fmt.Errorf("ioutil.ReadAll(outPipe): %v", err)
 	}	
 	var stderrData []byte
 	if stderrData, err = ioutil.ReadAll(errPipe); err != nil {
-		return "", fmt.Errorf("ioutil.ReadAll(errPipe): %v", err)
+		return nil, fmt.Errorf("ioutil.ReadAll(errPipe): %v", err)
+	}
+	stdoutData, stderrData = toLF(stdoutData), toLF(stderrData)
+	outPipe.Close()
+	errPipe.Close()
+	// cmd.Wait does not expose the resource usage, so wait for the process
+	// directly. Windows reports none, so the CPU time stays 0 there.
+	var msg *os.Waitmsg
+	if msg, err = cmd.Process.Wait(os.WRUSAGE); err != nil {
+		return nil, fmt.Errorf("cmd.Wait: %v", err)
 	}
-	if err = cmd.Wait(); err != nil {
-		return "", fmt.Errorf("cmd.Wait: %v", err)
+	end := monotonicNanoseconds()
+	timedOut := false
+	select {
+	case timedOut = <-expired:
+	default:
+	}
+	if !msg.Exited() || msg.ExitStatus() != 0 {
+		return nil, &llcFailure{msg, string(stderrData), timedOut}
+	}
+	out = &testOutput{stdout: string(stdoutData), stderr: string(stderrData),
+		wallSeconds: float64(end-start) / 1e9}
+	if msg.Rusage != nil {
+		out.maxRSS = msg.Rusage.Maxrss
+		out.cpuSeconds = float64(msg.Rusage.Utime.Sec+msg.Rusage.Stime.Sec) +
+			float64(msg.Rusage.Utime.Usec+msg.Rusage.Stime.Usec)/1e6
 	}
-	stderr = string(stderrData)
 	return
 }
 
-func parseTestOutput(stderr string) (res *Stats) {
-	res = new(Stats)
+// parseTestOutput parses the -stats and --time-passes output of the
+// toolchain's release.
+func parseTestOutput(tc *Toolchain, stderr string) (res *Stats) {
+	res = &Stats{Counters: make(map[string]int)}
+	dialect := tc.statsDialect()
 	for _, line := range strings.Split(stderr, "\n") {
 		line = strings.TrimSpace(line)
-		if asmInstrsRegexp.MatchString(line) {
-			ss := asmInstrsRegexp.FindStringSubmatch(line)
-			if len(ss) != 2 {
-				log.Printf("parseTestOutput: could not parse AsmInstrs statistic for line=[%s]", line)
-				continue
-			}
-			var err os.Error
-			if res.AsmInstrs, err = strconv.Atoi(ss[1]); err != nil {
-				log.Printf("parseTestOutput: could not parse int value of AsmInstrs statistic " +
-                                    "for line=[%s], matched substring=[%s], err: %v", line, ss[1], err)
-				continue
+		if ss := passTimeRegexp.FindStringSubmatch(line); len(ss) == 3 && ss[2] != "Total" {
+			// The last column is the wall time.
+			times := passTimeValueRegexp.FindAllStringSubmatch(ss[1], -1)
+			if wall, err := strconv.Atof64(times[len(times)-1][1]); err == nil {
+				if res.PassTimes == nil {
+					res.PassTimes = make(map[string]float64)
+				}
+				res.PassTimes[ss[2]] += wall
 			}
 		}
-		if stackSpaceRegexp.MatchString(line) {
-			ss := stackSpaceRegexp.FindStringSubmatch(line)
-			if len(ss) != 2 {
-				log.Printf("parseTestOutput: could not parse StackSpace statistic for line=[%s]", line)
-				continue
-			}
-			var err os.Error
-			if res.StackSpace, err = strconv.Atoi(ss[1]); err != nil {
-				log.Printf("parseTestOutput: could not parse int value of StackSpace statistic " +
-                                    "for line=[%s], matched substring=[%s], err: %v", line, ss[1], err)
-				continue
+		if ss := statRegexp.FindStringSubmatch(line); len(ss) == 4 {
+			if v, err := strconv.Atoi(ss[1]); err == nil {
+				res.Counters[dialect.counterKey(ss[2], ss[3])] += v
 			}
 		}
-		if execTimeRegexp.MatchString(line) {
-			ss := execTimeRegexp.FindStringSubmatch(line)
-			if len(ss) != 3 {
-				log.Printf("parseTestOutput: could not parse ExecTime statistic for line=[%s]", line)
+		if ss := execTimeRegexp.FindStringSubmatch(line); len(ss) == 3 {
+			// Every timer group prints its own total; the largest one is
+			// the pass execution report, which covers the others.
+			seconds, err := strconv.Atof64(ss[1])
+			if err != nil {
+				log.Printf("parseTestOutput: could not parse the total time in line=[%s]: %v", line, err)
 				continue
 			}
-			var err os.Error
-			if res.Seconds, err = strconv.Atof64(ss[1]); err != nil {
-				log.Printf("parseTestOutput: could not parse int value of Seconds statistic " +
-					"for line=[%s], matched substring=[%s], err: %v", line, ss[1], err)
+			if seconds < res.Seconds {
 				continue
 			}
-			if res.WallSeconds, err = strconv.Atof64(ss[2]); err != nil {
-				log.Printf("parseTestOutput: could not parse int value of WallSeconds statistic " +
-					"for line=[%s], matched substring=[%s], err: %v", line, ss[2], err)
-				continue
+			res.Seconds, res.WallSeconds = seconds, seconds
+			if ss[2] != "" {
+				if res.WallSeconds, err = strconv.Atof64(ss[2]); err != nil {
+					log.Printf("parseTestOutput: could not parse the wall time in line=[%s]: %v", line, err)
+				}
 			}
 		}
 	}
+	res.Diagnostics = parseDiagnostics(stderr)
+	res.AsmInstrs = res.Counters[asmInstrsKey]
+	res.StackSpace = res.Counters[stackSpaceKey]
 	return
 }
 
-func runAndParse(toolchain, test string) (stats *Stats, err os.Error) {
-	var stderr string
-	if stderr, err = runTest(toolchain, test); err != nil {
+type runMode struct {
+	stats      bool // run llc with -stats
+	timePasses bool // run llc with --time-passes
+	deep       bool // do the expensive analyses of the output
+}
+
+var (
+	crashCheckRun = runMode{}
+	quickRun      = runMode{stats: true}
+	warmupRun     = runMode{stats: true, timePasses: true}
+	deepRun       = runMode{stats: true, timePasses: true, deep: true}
+)
+
+// keepsAsm reports whether runAndParse keeps the assembly in Stats.Asm in the
+// mode, for the snapshots, the artifacts, the function diffs, the hooks or
+// the -tui.
+func keepsAsm(mode runMode) bool {
+	return *goldenDir != "" ||
+		(mode.deep && (*artifactDir != "" || *functionDiff || *postCompareCmd != "" || *tuiFlag))
+}
+
+func runAndParse(tc *Toolchain, test string, mode runMode) (stats *Stats, err os.Error) {
+	var asm string
+	if asm, stats, err = tc.driver().Compile(tc, test, mode); err != nil {
 		return
 	}
-	stats = parseTestOutput(stderr)
+	warnMissingStats(tc, mode, asm, stats)
+	subtractOverhead(tc, stats)
+	// stdout is the text which is compared: the assembly, or its
+	// disassembly with -disasm=instead.
+	stdout := asm
+	if *disasm != "" {
+		var text string
+		if text, err = disassemble(tc, asm); err != nil {
+			return nil, fmt.Errorf("disassemble: %v", err)
+		}
+		if *disasm == "instead" {
+			stdout = text
+		} else {
+			stats.DisasmHash = asmHash(text)
+		}
+	}
+	stats.AsmBytes = len(stdout)
+	stats.AsmLines = strings.Count(stdout, "\n")
+	stats.AsmHash = asmHash(stdout)
+	stats.Categories = countInstrCategories(stdout)
+	countTables(asm, stats)
+	if keepsAsm(mode) {
+		stats.Asm = stdout
+	}
+	if !mode.deep {
+		return
+	}
+	if *compressedSize {
+		if stats.AsmGzipBytes, err = gzipSize([]byte(stdout)); err != nil {
+			return nil, fmt.Errorf("gzipSize: %v", err)
+		}
+	}
+	if *perFunction > 0 {
+		stats.Functions = countFunctionInstrs(stdout)
+	}
+	if *padding {
+		if err = measurePadding(tc, asm, stats); err != nil {
+			return nil, fmt.Errorf("measurePadding: %v", err)
+		}
+	}
+	if *instrBytes && !*padding {
+		if stats.InstrBytes, err = encodedBytes(tc, asm); err != nil {
+			return nil, fmt.Errorf("encodedBytes: %v", err)
+		}
+	}
+	if *sectionSizesFlag {
+		if err = measureSections(tc, asm, stats); err != nil {
+			return nil, fmt.Errorf("measureSections: %v", err)
+		}
+	}
+	if *unwind {
+		if err = measureUnwind(tc, asm, stats); err != nil {
+			return nil, fmt.Errorf("measureUnwind: %v", err)
+		}
+	}
+	if *link {
+		if err = linkObject(tc, asm, stats); err != nil {
+			return nil, fmt.Errorf("linkObject: %v", err)
+		}
+	}
+	if *runBinaries {
+		if err = benchmark(tc, test, asm, stats); err != nil {
+			if _, ok := err.(*runtimeCrash); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("benchmark: %v", err)
+		}
+	}
+	if *mcCounters {
+		if err = mcStats(tc, asm, stats); err != nil {
+			return nil, fmt.Errorf("mcStats: %v", err)
+		}
+	}
+	if err = collectMetrics(&Artifacts{tc, test, asm}, stats); err != nil {
+		return nil, err
+	}
+	if err = postCompileHook(tc, test, stdout, stats); err != nil {
+		return nil, err
+	}
 	return
 }
 
-func runBoth(t1, t2, test string) (stats [2]*Stats, err os.Error) {
-	if stats[0], err = runAndParse(t1, test); err != nil {
-		log.Fatalf("runTest(t1=%s, test=%s): %v", t1, test, err) 
+// runBoth runs the test with both toolchains, even if the first one fails,
+// so that the error tells the status of each.
+func runBoth(tcs [2]*Toolchain, test string, mode runMode) (stats [2]*Stats, err os.Error) {
+	var errs [2]os.Error
+	for i, tc := range tcs {
+		if stats[i], errs[i] = runAndParse(tc, test, mode); errs[i] != nil {
+			errs[i] = &testError{tc, test, errs[i]}
+		}
 	}
-
-	if stats[1], err = runAndParse(t2, test); err != nil {
-		log.Fatalf("runTest(t2=%s, test=%s): %v", t2, test, err) 
+	if errs[0] != nil || errs[1] != nil {
+		return stats, &runError{errs}
 	}
 	return
 }
 
-func printStats(stats [2]*Stats) (err os.Error) {
-	fmt.Printf("%s\t%d\t%d\t%v\t%v\t%d\t%d\t%v\t%v\n", path.Base(*test),
-		stats[0].AsmInstrs, stats[0].StackSpace, stats[0].Seconds, stats[0].WallSeconds,
-		stats[1].AsmInstrs, stats[1].StackSpace, stats[1].Seconds, stats[1].WallSeconds)
-	return
+// expandTests replaces "-" in the test list with the paths read from stdin,
+// downloads the remote tests and replaces the archives with their tests.
+func expandTests(tests []string) (res []string, err os.Error) {
+	var entries []string
+	for _, tst := range tests {
+		if tst != "-" {
+			entries = append(entries, tst)
+			continue
+		}
+		r := bufio.NewReader(os.Stdin)
+		for {
+			var line string
+			line, err = r.ReadString('\n')
+			if line = strings.TrimSpace(line); line != "" {
+				entries = append(entries, line)
+			}
+			if err == os.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("reading tests from stdin: %v", err)
+			}
+		}
+	}
+	for _, tst := range entries {
+		if isRemote(tst) {
+			if tst, err = fetchTest(tst); err != nil {
+				return
+			}
+		}
+		if isArchive(tst) {
+			var members []string
+			if members, err = expandArchive(tst); err != nil {
+				return nil, fmt.Errorf("reading tests from %s: %v", tst, err)
+			}
+			res = append(res, members...)
+			continue
+		}
+		res = append(res, tst)
+	}
+	return res, nil
 }
 
 func checkArg(name string, cond bool) {
@@ -153,22 +610,200 @@ func checkArg(name string, cond bool) {
 	}
 }
 
-func main() {
-	flag.Parse()
-	checkArg("-test", *test != "")
+// collectTests returns the -test flag and the given arguments as the list of
+// tests, with "-" and archives expanded.
+func collectTests(args []string) (tests []string) {
+	tests = args
+	if *test != "" {
+		tests = append([]string{*test}, tests...)
+	}
+	var err os.Error
+	if tests, err = expandTests(tests); err != nil {
+		log.Fatalf("expandTests: %v", err)
+	}
+	if *skipFile != "" {
+		tests = skipTests(tests)
+	}
+	checkArg("-test", len(tests) > 0)
+	return
+}
+
+// parseToolchains parses the -t1 and -t2 flags.
+func parseToolchains() (tcs [2]*Toolchain) {
 	checkArg("-t1", *t1 != "")
 	checkArg("-t2", *t2 != "")
-
-	fmt.Printf("Running test: %s\n", *test)
 	var err os.Error
-	var stats [2]*Stats
-	if _, err = runBoth(*t1, *t2, *test); err != nil {
-		log.Fatalf("runBoth: %v", err)
+	for i, spec := range []string{*t1, *t2} {
+		if tcs[i], err = parseToolchain(fmt.Sprintf("t%d", i+1), spec); err != nil {
+			log.Fatalf("parseToolchain: %v", err)
+		}
+	}
+	if tcs[0].Name == tcs[1].Name {
+		log.Fatalf("Both toolchains are named %q", tcs[0].Name)
+	}
+	for _, tc := range tcs {
+		if err = tc.fetch(); err != nil {
+			log.Fatalf("fetch: %v", err)
+		}
+		if err = tc.preflight(); err != nil {
+			log.Fatalf("preflight: %v", err)
+		}
+	}
+	if err = checkVersionSkew(tcs); err != nil {
+		log.Fatalf("checkVersionSkew: %v", err)
+	}
+	return
+}
+
+// compareTests runs the tests with both toolchains and returns the report,
+// which is not finished yet.
+func compareTests(tcs [2]*Toolchain, tests []string) (run *Run, rep *report, err os.Error) {
+	if *disasm != "" && *disasm != "also" && *disasm != "instead" {
+		return nil, nil, fmt.Errorf("Unknown -disasm value: %s", *disasm)
+	}
+	if *symbolDiff > 0 && !*link {
+		return nil, nil, os.NewError("-symbol-diff needs -link")
+	}
+	if *startup && !*runBinaries {
+		return nil, nil, os.NewError("-startup needs -run")
+	}
+	if *perfCounters && !*runBinaries {
+		return nil, nil, os.NewError("-perf needs -run")
+	}
+	if *runStack && !*runBinaries {
+		return nil, nil, os.NewError("-run-stack needs -run")
+	}
+	if *runHeap && !*runBinaries {
+		return nil, nil, os.NewError("-run-heap needs -run")
+	}
+	if *checkOutput && !*runBinaries {
+		return nil, nil, os.NewError("-check-output needs -run")
 	}
-	if stats, err = runBoth(*t1, *t2, *test); err != nil {
-		log.Fatalf("runBoth(2): %v", err)
+	if *runManifestFile != "" {
+		if !*runBinaries {
+			return nil, nil, os.NewError("-run-manifest needs -run")
+		}
+		if runManifest, err = loadRunManifest(*runManifestFile); err != nil {
+			return nil, nil, fmt.Errorf("loadRunManifest: %v", err)
+		}
+	}
+	if *deviceSpec != "" {
+		if dev, err = parseDevice(*deviceSpec); err != nil {
+			return nil, nil, fmt.Errorf("parseDevice: %v", err)
+		}
+		if *startup || *perfCounters {
+			return nil, nil, os.NewError("-startup and -perf do not work with -device")
+		}
+	}
+	if *pipelineFile != "" {
+		if pipeline, err = loadPipeline(*pipelineFile); err != nil {
+			return nil, nil, fmt.Errorf("loadPipeline: %v", err)
+		}
+	}
+	if *counterAliasesFile != "" {
+		if counterAliases, err = loadCounterAliases(*counterAliasesFile); err != nil {
+			return nil, nil, fmt.Errorf("loadCounterAliases: %v", err)
+		}
 	}
-	if err = printStats(stats); err != nil {
-		log.Fatalf("printStats: %v", err)
+	if !logPolicies[*keepLogsFlag] {
+		return nil, nil, fmt.Errorf("Unknown -keep-logs value: %s", *keepLogsFlag)
 	}
-}
\ No newline at end of file
+	if *subtractOverheadFlag && *calibrateRuns <= 0 {
+		return nil, nil, os.NewError("-subtract-overhead needs -calibrate")
+	}
+	setAsmTarget(targetTriple(tcs[0]))
+	if err = checkCPUScaling(); err != nil {
+		return
+	}
+	if err = setupIsolatedCPUs(); err != nil {
+		return
+	}
+	if err = calibrateOverhead(tcs); err != nil {
+		return
+	}
+	if *incremental != "" {
+		if cache, err = loadCache(*incremental, tcs); err != nil {
+			return nil, nil, fmt.Errorf("loadCache: %v", err)
+		}
+	}
+	run = newRun(tcs)
+	if rep, err = newReport(tcs); err != nil {
+		return nil, nil, err
+	}
+	if *bundleOut != "" {
+		captureLog()
+	}
+	if *tuiFlag {
+		rep.tui = startTUI(rep)
+	}
+	if *dedup {
+		tests, rep.aliases = dedupTests(tests)
+	}
+	monitor := startThermalMonitor()
+	if *twoPhase {
+		runTwoPhase(tcs, tests, rep)
+	} else {
+		runTests(tcs, tests, rep)
+	}
+	monitor.finish(append(append([]*Result(nil), rep.results...), rep.failed...))
+	if rep.tui != nil {
+		rep.tui.finish()
+	}
+	if *reduceDir != "" {
+		reduceResults(tcs, rep)
+	}
+	cleanupInputs()
+	cleanupArchives()
+	if err = cache.save(); err != nil {
+		return run, rep, fmt.Errorf("cache.save: %v", err)
+	}
+	return
+}
+
+// runCommand compares the toolchains on the tests.
+func runCommand(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	tests := collectTests(fs.Args())
+	tcs := parseToolchains()
+	if *crashOnly {
+		if runCrashOnly(tcs, tests) && exitLevels[*exitOn] > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	// The difftool needs the assembly files, so keep them for the session.
+	tmpDir := ""
+	if *difftool != "" && *artifactDir == "" {
+		var err os.Error
+		if tmpDir, err = ioutil.TempDir("", "llvm-side-by-side"); err != nil {
+			log.Fatalf("ioutil.TempDir: %v", err)
+		}
+		flag.Set("artifact-dir", tmpDir)
+	}
+	run, rep, err := compareTests(tcs, tests)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rep.write(run)
+	if *difftool != "" {
+		runDifftool(tcs, rep.results)
+	}
+	if tmpDir != "" {
+		os.RemoveAll(tmpDir)
+	}
+	if rep.failing() {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	args := os.Args[1:]
+	// Without a subcommand, the arguments are those of run.
+	cmd := commands[0]
+	if len(args) > 0 {
+		if c := findCommand(args[0]); c != nil {
+			cmd, args = c, args[1:]
+		}
+	}
+	cmd.run(newFlagSet(cmd), args)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"rand"
+	"strings"
+)
+
+// flagSpace has one dimension per line of its file. A dimension lists the
+// alternative llc flags separated by '|'; an empty alternative leaves the
+// flag out, e.g. "|-global-isel".
+type flagSpace [][]string
+
+func loadFlagSpace(filename string) (space flagSpace, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		var alts []string
+		for _, alt := range strings.Split(line, "|") {
+			alts = append(alts, strings.TrimSpace(alt))
+		}
+		space = append(space, alts)
+	}
+	if len(space) == 0 {
+		return nil, fmt.Errorf("%s: no flag dimensions", filename)
+	}
+	return
+}
+
+// size returns the number of combinations, or limit if there are more.
+func (space flagSpace) size(limit int) int {
+	n := 1
+	for _, alts := range space {
+		if n *= len(alts); n >= limit {
+			return limit
+		}
+	}
+	return n
+}
+
+func (space flagSpace) args(choices []int) (args []string) {
+	for i, c := range choices {
+		args = append(args, strings.Fields(space[i][c])...)
+	}
+	return
+}
+
+// sample returns n distinct combinations, or all of them if there are no
+// more than n. A combination holds the chosen alternative of each dimension.
+func (space flagSpace) sample(rnd *rand.Rand, n int) (combos [][]int) {
+	if space.size(n+1) <= n {
+		choices := make([]int, len(space))
+		for {
+			combos = append(combos, append([]int(nil), choices...))
+			i := 0
+			for ; i < len(choices); i++ {
+				if choices[i]++; choices[i] < len(space[i]) {
+					break
+				}
+				choices[i] = 0
+			}
+			if i == len(choices) {
+				return
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for len(combos) < n {
+		choices := make([]int, len(space))
+		for i, alts := range space {
+			choices[i] = rnd.Intn(len(alts))
+		}
+		if key := fmt.Sprint(choices); !seen[key] {
+			seen[key] = true
+			combos = append(combos, choices)
+		}
+	}
+	return
+}
+
+type matrixCell struct {
+	choices []int
+	args    []string
+	rep     *report
+}
+
+func (c *matrixCell) counts() (regressions, divergences, failures int) {
+	for _, res := range c.rep.results {
+		if res.severity(c.rep.limits) > 0 {
+			regressions++
+		}
+		if res.diverged() {
+			divergences++
+		}
+	}
+	for _, res := range c.rep.failed {
+		if !res.incompatible() && !res.vetoed() {
+			failures++
+		}
+	}
+	return
+}
+
+// cellFilename inserts the cell number before the extension of filename.
+func cellFilename(filename string, cell int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s.%d%s", filename[:len(filename)-len(ext)], cell, ext)
+}
+
+// matrixCommand compares the toolchains with a sample of the combinations of
+// llc flags from a flag space file, one full run per combination.
+func matrixCommand(fs *flag.FlagSet, args []string) {
+	spaceFile := fs.String("flag-space", "", "File with one dimension of llc flags per line, "+
+		"the alternatives separated by '|'")
+	samples := fs.Int("samples", 20, "Number of flag combinations to run")
+	seed := fs.Int64("seed", 1, "Seed for sampling the flag combinations")
+	fs.Parse(args)
+	checkArg("-flag-space", *spaceFile != "")
+	tests := collectTests(fs.Args())
+	tcs := parseToolchains()
+	space, err := loadFlagSpace(*spaceFile)
+	if err != nil {
+		log.Fatalf("loadFlagSpace: %v", err)
+	}
+
+	baseArgs, baseHTML, baseBundle := *llcFlags, *htmlOut, *bundleOut
+	var cells []*matrixCell
+	failing := false
+	for i, choices := range space.sample(rand.New(rand.NewSource(*seed)), *samples) {
+		cell := &matrixCell{choices: choices, args: space.args(choices)}
+		flag.Set("llc-args", strings.TrimSpace(baseArgs+" "+strings.Join(cell.args, " ")))
+		if baseHTML != "" {
+			flag.Set("html", cellFilename(baseHTML, i))
+		}
+		if baseBundle != "" {
+			flag.Set("bundle", cellFilename(baseBundle, i))
+		}
+		fmt.Printf("\nConfiguration %d: %s\n", i, *llcFlags)
+		var run *Run
+		if run, cell.rep, err = compareTests(tcs, tests); err != nil {
+			log.Fatal(err)
+		}
+		run.ID = fmt.Sprintf("%s.%d", run.ID, i)
+		run.Labels = make(map[string]string)
+		for k, v := range labels {
+			run.Labels[k] = v
+		}
+		run.Labels["llc_args"] = *llcFlags
+		cell.rep.write(run)
+		// Later cells add their results to the same -out file.
+		flag.Set("append", "true")
+		failing = failing || cell.rep.failing()
+		cells = append(cells, cell)
+	}
+
+	fmt.Printf("\nFlag matrix:\ncell\tregressions\tdivergences\tfailures\tllc args\n")
+	for i, cell := range cells {
+		regressions, divergences, failures := cell.counts()
+		fmt.Printf("%d\t%d\t%d\t%d\t%s\n", i, regressions, divergences, failures, strings.Join(cell.args, " "))
+	}
+	printAttribution(space, cells)
+	if failing {
+		os.Exit(1)
+	}
+}
+
+// altName names an alternative of a dimension; the empty alternative is
+// named after the flags it leaves out.
+func (space flagSpace) altName(dim, alt int) string {
+	if space[dim][alt] != "" {
+		return space[dim][alt]
+	}
+	var others []string
+	for _, a := range space[dim] {
+		if a != "" {
+			others = append(others, a)
+		}
+	}
+	return "no " + strings.Join(others, "/")
+}
+
+// attribute returns the flag alternatives which exactly separate the cells
+// where the test regressed from the cells where it did not.
+func attribute(space flagSpace, cells []*matrixCell, regressed map[int]bool) (flags []string) {
+	for dim, alts := range space {
+		for alt := range alts {
+			with, exact := 0, true
+			for i, cell := range cells {
+				r, ran := regressed[i]
+				if !ran {
+					continue
+				}
+				if cell.choices[dim] == alt {
+					with++
+				}
+				if r != (cell.choices[dim] == alt) {
+					exact = false
+					break
+				}
+			}
+			if exact && with > 0 {
+				flags = append(flags, space.altName(dim, alt))
+			}
+		}
+	}
+	return
+}
+
+// printAttribution lists the tests which regressed in some of the cells but
+// not in the others, with the flags which explain it.
+func printAttribution(space flagSpace, cells []*matrixCell) {
+	regressed := make(map[string]map[int]bool)
+	var tests []string
+	for i, cell := range cells {
+		for _, res := range cell.rep.results {
+			m, ok := regressed[res.Test]
+			if !ok {
+				m = make(map[int]bool)
+				regressed[res.Test] = m
+				tests = append(tests, res.Test)
+			}
+			m[i] = res.severity(cell.rep.limits) > 0
+		}
+	}
+	fmt.Printf("\nRegression attribution:\ntest\tregressed\tflags\n")
+	for _, test := range tests {
+		n := 0
+		for _, r := range regressed[test] {
+			if r {
+				n++
+			}
+		}
+		if n == 0 || n == len(regressed[test]) {
+			continue
+		}
+		explanation := "no single flag"
+		if flags := attribute(space, cells, regressed[test]); len(flags) > 0 {
+			explanation = "only with " + strings.Join(flags, " or ")
+		}
+		fmt.Printf("%s\t%d/%d\t%s\n", path.Base(test), n, len(regressed[test]), explanation)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"debug/macho"
+	"exec"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	encodingRegexp      = regexp.MustCompile(`encoding: \[([^\]]*)\]`)
+	disasmAddressRegexp = regexp.MustCompile(`^ *[0-9a-f]+:`)
+)
+
+// assemble turns the assembly into an object file with the toolchain's
+// llvm-mc, or takes it from -cas. The caller is responsible for removing the
+// returned file.
+func assemble(tc *Toolchain, asm string) (objFile string, err os.Error) {
+	var f *os.File
+	if f, err = ioutil.TempFile("", "llvm-side-by-side"); err != nil {
+		return
+	}
+	objFile = f.Name()
+	f.Close()
+	key := ""
+	if *casDir != "" {
+		if key, err = casKey(tc.tool("llvm-mc"), []string{"-filetype=obj"}, dataHash([]byte(asm))); err != nil {
+			os.Remove(objFile)
+			return "", err
+		}
+		if _, ok := casLoad(*casDir, key, objFile); ok {
+			return
+		}
+	}
+	if _, err = tc.run([]byte(asm), "llvm-mc", "-filetype=obj", "-o", objFile); err != nil {
+		os.Remove(objFile)
+		return "", err
+	}
+	if key != "" {
+		casStore(*casDir, key, &testOutput{}, objFile)
+	}
+	return
+}
+
+// disassemble assembles the assembly with llvm-mc and disassembles the object
+// with llvm-objdump. The addresses are dropped, so that an inserted
+// instruction only changes its own line, but the encodings are kept.
+func disassemble(tc *Toolchain, asm string) (disasm string, err os.Error) {
+	var objFile string
+	if objFile, err = assemble(tc, asm); err != nil {
+		return
+	}
+	defer os.Remove(objFile)
+	var out []byte
+	if out, err = tc.run(nil, "llvm-objdump", "-d", objFile); err != nil {
+		return
+	}
+	var lines []string
+	started := false
+	for _, line := range strings.Split(string(out), "\n") {
+		// The header names the temporary object file.
+		if !started {
+			started = strings.HasPrefix(line, "Disassembly of section")
+			if !started {
+				continue
+			}
+		}
+		lines = append(lines, disasmAddressRegexp.ReplaceAllString(line, ""))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// textSize returns the total size of the .text sections of an ELF object,
+// or of the __text sections of a Mach-O one.
+func textSize(objFile string) (size int, err os.Error) {
+	var f *elf.File
+	if f, err = elf.Open(objFile); err != nil {
+		if mf, merr := macho.Open(objFile); merr == nil {
+			defer mf.Close()
+			for _, sect := range mf.Sections {
+				if sect.Name == "__text" {
+					size += int(sect.Size)
+				}
+			}
+			return size, nil
+		}
+		return
+	}
+	defer f.Close()
+	for _, sect := range f.Sections {
+		if sect.Name == ".text" || strings.HasPrefix(sect.Name, ".text.") {
+			size += int(sect.Size)
+		}
+	}
+	return
+}
+
+// encodedBytes sums the encoding lengths llvm-mc reports for every
+// instruction in the assembly.
+func encodedBytes(tc *Toolchain, asm string) (n int, err os.Error) {
+	var out []byte
+	if out, err = tc.run([]byte(asm), "llvm-mc", "-show-encoding"); err != nil {
+		return
+	}
+	for _, ss := range encodingRegexp.FindAllStringSubmatch(string(out), -1) {
+		if enc := strings.TrimSpace(ss[1]); enc != "" {
+			n += len(strings.Split(enc, ","))
+		}
+	}
+	return
+}
+
+// measurePadding fills in the text size, the encoded instruction bytes and
+// their difference, which is the alignment padding in .text.
+func measurePadding(tc *Toolchain, asm string, stats *Stats) (err os.Error) {
+	var objFile string
+	if objFile, err = assemble(tc, asm); err != nil {
+		return
+	}
+	defer os.Remove(objFile)
+	if stats.TextBytes, err = textSize(objFile); err != nil {
+		return fmt.Errorf("textSize: %v", err)
+	}
+	if stats.InstrBytes, err = encodedBytes(tc, asm); err != nil {
+		return
+	}
+	stats.PaddingBytes = stats.TextBytes - stats.InstrBytes
+	if *compressedSize {
+		var obj []byte
+		if obj, err = ioutil.ReadFile(objFile); err != nil {
+			return
+		}
+		if stats.ObjGzipBytes, err = gzipSize(obj); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// mcStats assembles the assembly with llvm-mc -stats and adds the counters of
+// the MC layer, such as the evaluated fixups and the relaxed fragments.
+func mcStats(tc *Toolchain, asm string, stats *Stats) (err os.Error) {
+	cmd := exec.Command(tc.tool("llvm-mc"), "-filetype=obj", "-stats", "-o", os.DevNull)
+	var errBuf bytes.Buffer
+	cmd.Stdin = bytes.NewBufferString(asm)
+	cmd.Stderr = &errBuf
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("llvm-mc -stats: %v, stderr: %s", err, errBuf.String())
+	}
+	for _, line := range strings.Split(string(toLF(errBuf.Bytes())), "\n") {
+		if ss := statRegexp.FindStringSubmatch(strings.TrimSpace(line)); len(ss) == 4 && ss[2] == "assembler" {
+			if v, err := strconv.Atoi(ss[1]); err == nil {
+				stats.Counters[ss[2]+" - "+ss[3]] += v
+			}
+		}
+	}
+	return
+}
+
+func gzipSize(data []byte) (n int, err os.Error) {
+	var buf bytes.Buffer
+	var w *gzip.Compressor
+	if w, err = gzip.NewWriter(&buf); err != nil {
+		return
+	}
+	if _, err = w.Write(data); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return buf.Len(), nil
+}
+
+// sectionSizes returns the size and the content hash of every section of an
+// ELF or Mach-O object; the Mach-O sections are named segment,section. The
+// sections without contents in the file, like .bss, have no hash.
+func sectionSizes(objFile string) (sizes map[string]int, hashes map[string]string, err os.Error) {
+	sizes, hashes = make(map[string]int), make(map[string]string)
+	var f *elf.File
+	if f, err = elf.Open(objFile); err != nil {
+		mf, merr := macho.Open(objFile)
+		if merr != nil {
+			return
+		}
+		defer mf.Close()
+		for _, sect := range mf.Sections {
+			name := sect.Seg + "," + sect.Name
+			sizes[name] += int(sect.Size)
+			if data, err := sect.Data(); err == nil {
+				hashes[name] = dataHash(data)
+			}
+		}
+		return sizes, hashes, nil
+	}
+	defer f.Close()
+	for _, sect := range f.Sections {
+		if sect.Name == "" {
+			continue
+		}
+		sizes[sect.Name] += int(sect.Size)
+		if sect.Type == elf.SHT_NOBITS {
+			continue
+		}
+		if data, err := sect.Data(); err == nil {
+			hashes[sect.Name] = dataHash(data)
+		}
+	}
+	return
+}
+
+// measureSections fills in the sizes and content hashes of the sections of
+// the assembled output.
+func measureSections(tc *Toolchain, asm string, stats *Stats) (err os.Error) {
+	var objFile string
+	if objFile, err = assemble(tc, asm); err != nil {
+		return
+	}
+	defer os.Remove(objFile)
+	stats.Sections, stats.SectionHashes, err = sectionSizes(objFile)
+	return
+}
+
+// printSectionDeltas prints the total size of every section with both
+// toolchains and the number of tests in which it changed in size or
+// contents, then the size changes of each test.
+func printSectionDeltas(tcs [2]*Toolchain, results []*Result) {
+	var totals [2]map[string]int
+	totals[0], totals[1] = make(map[string]int), make(map[string]int)
+	changed := make(map[string]int)
+	for _, res := range results {
+		for i, s := range res.Stats {
+			for name, size := range s.Sections {
+				totals[i][name] += size
+			}
+		}
+		seen := make(map[string]bool)
+		for _, s := range res.Stats {
+			for name := range s.Sections {
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				a, b := res.Stats[0], res.Stats[1]
+				if a.Sections[name] != b.Sections[name] || a.SectionHashes[name] != b.SectionHashes[name] {
+					changed[name]++
+				}
+			}
+		}
+	}
+	fmt.Printf("\nSection sizes:\nsection\t%s\t%s\tdelta\tchanged tests\n", tcs[0].Name, tcs[1].Name)
+	for _, d := range countDeltas(totals, len(totals[0])+len(totals[1])) {
+		fmt.Printf("%s\t%d\t%d\t%+d\t%d\n", d.Name, d.Counts[0], d.Counts[1], d.delta(), changed[d.Name])
+	}
+	// The sections whose contents changed without changing the total size.
+	var same []string
+	for name := range changed {
+		if totals[0][name] == totals[1][name] {
+			same = append(same, name)
+		}
+	}
+	sort.Strings(same)
+	for _, name := range same {
+		fmt.Printf("%s\t%d\t%d\t+0\t%d\n", name, totals[0][name], totals[1][name], changed[name])
+	}
+	fmt.Printf("\nSection size changes per test:\ntest\tsection\t%s\t%s\tdelta\n", tcs[0].Name, tcs[1].Name)
+	for _, res := range results {
+		for _, d := range countDeltas([2]map[string]int{res.Stats[0].Sections, res.Stats[1].Sections}, -1) {
+			fmt.Printf("%s\t%s\t%d\t%d\t%+d\n", path.Base(res.Test), d.Name, d.Counts[0], d.Counts[1], d.delta())
+		}
+	}
+}
+
+// unwindSections are the ELF and Mach-O sections of the unwind and exception
+// tables.
+var unwindSections = []string{".eh_frame", ".eh_frame_hdr", ".ARM.exidx", ".ARM.extab", ".gcc_except_table",
+	"__TEXT,__eh_frame", "__LD,__compact_unwind", "__TEXT,__gcc_except_tab"}
+
+// measureUnwind fills in the size of the unwind tables of the assembled
+// output and the number of CFI instructions in the assembly.
+func measureUnwind(tc *Toolchain, asm string, stats *Stats) (err os.Error) {
+	stats.CFIInstrs = countCFIInstrs(asm)
+	sizes := stats.Sections
+	if sizes == nil {
+		var objFile string
+		if objFile, err = assemble(tc, asm); err != nil {
+			return
+		}
+		defer os.Remove(objFile)
+		if sizes, _, err = sectionSizes(objFile); err != nil {
+			return
+		}
+	}
+	stats.UnwindBytes = 0
+	for _, name := range unwindSections {
+		stats.UnwindBytes += sizes[name]
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// tolerance bounds the difference between two numbers in the outputs of the
+// -run executables which still counts as the same output.
+type tolerance struct {
+	abs, rel float64
+}
+
+func (t tolerance) equal(x, y float64) bool {
+	if x == y || math.IsNaN(x) && math.IsNaN(y) {
+		return true
+	}
+	d := math.Fabs(x - y)
+	return d <= t.abs || d <= t.rel*math.Fmax(math.Fabs(x), math.Fabs(y))
+}
+
+// loadTolerances reads the per-test overrides of -output-abs-epsilon and
+// -output-rel-epsilon. Each line has the form "<test>: abs=<eps>,rel=<eps>",
+// where <test> is the path or the base name of the test and either epsilon
+// may be left out.
+func loadTolerances(filename string, def tolerance) (tols map[string]tolerance, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	tols = make(map[string]tolerance)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		kv := strings.SplitN(line, ": ", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<test>: abs=<eps>,rel=<eps>\"", filename, i+1)
+		}
+		var eps map[string]float64
+		if eps, err = parseThresholds(kv[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, i+1, err)
+		}
+		t := def
+		for k, v := range eps {
+			switch k {
+			case "abs":
+				t.abs = v
+			case "rel":
+				t.rel = v
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown epsilon %q, expected abs or rel", filename, i+1, k)
+			}
+		}
+		tols[strings.TrimSpace(kv[0])] = t
+	}
+	return
+}
+
+func (r *report) toleranceFor(test string) tolerance {
+	if t, ok := r.tolerances[test]; ok {
+		return t
+	}
+	if t, ok := r.tolerances[path.Base(test)]; ok {
+		return t
+	}
+	return tolerance{*outputAbsEpsilon, *outputRelEpsilon}
+}
+
+// compareOutputs compares the outputs word by word, the words which parse
+// as numbers within the tolerance. It returns the first difference, or ""
+// if the outputs match.
+func compareOutputs(out1, out2 string, t tolerance) string {
+	lines1, lines2 := strings.Split(out1, "\n"), strings.Split(out2, "\n")
+	for i := 0; i < len(lines1) || i < len(lines2); i++ {
+		if i >= len(lines1) || i >= len(lines2) {
+			return fmt.Sprintf("line %d: the outputs have %d and %d lines", i+1, len(lines1), len(lines2))
+		}
+		if lines1[i] == lines2[i] {
+			continue
+		}
+		words1, words2 := strings.Fields(lines1[i]), strings.Fields(lines2[i])
+		if len(words1) != len(words2) {
+			return fmt.Sprintf("line %d: %q vs %q", i+1, lines1[i], lines2[i])
+		}
+		for j, w1 := range words1 {
+			w2 := words2[j]
+			if w1 == w2 {
+				continue
+			}
+			x, err1 := strconv.Atof64(w1)
+			y, err2 := strconv.Atof64(w2)
+			if err1 != nil || err2 != nil || !t.equal(x, y) {
+				return fmt.Sprintf("line %d: %q vs %q", i+1, w1, w2)
+			}
+		}
+	}
+	return ""
+}
+
+// checkOutputs sets the OutputMismatch of a result whose executables
+// printed different outputs with -check-output.
+func (r *report) checkOutputs(res *Result) {
+	if !*checkOutput || res.Stats[0].RunOutput == nil || res.Stats[1].RunOutput == nil {
+		return
+	}
+	res.OutputMismatch = compareOutputs(*res.Stats[0].RunOutput, *res.Stats[1].RunOutput, r.toleranceFor(res.Test))
+}
+
+func printOutputMismatches(results []*Result) {
+	var mismatched []*Result
+	for _, res := range results {
+		if res.OutputMismatch != "" {
+			mismatched = append(mismatched, res)
+		}
+	}
+	if len(mismatched) == 0 {
+		return
+	}
+	fmt.Printf("\n%d tests whose executables printed different outputs:\ntest\tfirst difference\n", len(mismatched))
+	for _, res := range mismatched {
+		fmt.Printf("%s\t%s\n", path.Base(res.Test), res.OutputMismatch)
+	}
+}
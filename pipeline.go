@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// stage is one step of a -pipeline: a tool from the toolchain's bin
+// directory and its arguments, where {in} stands for the output of the
+// previous stage, or the test for the first one, and {out} for the output of
+// this stage.
+type stage struct {
+	// name is also the name of the output file, so its extension tells the
+	// format, e.g. "llc.s".
+	name string
+	tool string
+	args []string
+}
+
+// pipeline replaces the driver of both toolchains if -pipeline is given.
+var pipeline []*stage
+
+// loadPipeline reads a pipeline with one stage per line, in the form
+// "<name>: <tool> <args>", e.g. "opt.bc: opt -O2 {in} -o {out}".
+func loadPipeline(filename string) (stages []*stage, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<name>: <tool> <args>\"", filename, i+1)
+		}
+		name, fields := strings.TrimSpace(kv[0]), strings.Fields(kv[1])
+		if name == "" || strings.Contains(name, "/") || seen[name] || len(fields) == 0 {
+			return nil, fmt.Errorf("%s:%d: bad stage %q", filename, i+1, line)
+		}
+		seen[name] = true
+		stages = append(stages, &stage{name, fields[0], fields[1:]})
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("%s: no stages", filename)
+	}
+	return
+}
+
+func (s *stage) String() string {
+	return s.name + ": " + s.tool + " " + strings.Join(s.args, " ")
+}
+
+// run runs the stage on the input file, or copies its output and record
+// from an earlier run of the same stage with the same tool binary on the same
+// input, kept in -stage-cache or else -cas. Only the {out} file is kept.
+func (s *stage) run(tc *Toolchain, args []string, in, out string) (res *testOutput, err os.Error) {
+	dir := *stageCache
+	if dir == "" {
+		dir = *casDir
+	}
+	if dir == "" {
+		return runTimed(tc.tool(s.tool), args, nil)
+	}
+	var inHash, key string
+	if inHash, err = fileHash(in); err != nil {
+		return
+	}
+	if key, err = casKey(tc.tool(s.tool), []string{s.String()}, inHash); err != nil {
+		return
+	}
+	var ok bool
+	if res, ok = casLoad(dir, key, out); ok {
+		return
+	}
+	if res, err = runTimed(tc.tool(s.tool), args, nil); err == nil {
+		casStore(dir, key, res, out)
+	}
+	return
+}
+
+// pipelineDriver runs the -pipeline stages in order. Each stage gets the
+// metrics <stage>.seconds, <stage>.wall and <stage>.bytes, the size of its
+// output, and its -stats counters are prefixed with its name. The compared
+// assembly is the output of the last stage whose name ends in .s.
+type pipelineDriver struct{}
+
+func (pipelineDriver) Compiler() string {
+	return pipeline[0].tool
+}
+
+func (pipelineDriver) Compile(tc *Toolchain, test string, mode runMode) (asm string, stats *Stats, err os.Error) {
+	var dir string
+	if dir, err = ioutil.TempDir("", "llvm-side-by-side-pipeline"); err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	stats = &Stats{Counters: make(map[string]int), Extra: make(map[string]float64)}
+	in := inputFor(tc, test)
+	for _, s := range pipeline {
+		out := path.Join(dir, s.name)
+		var args []string
+		for _, arg := range s.args {
+			args = append(args, strings.Replace(strings.Replace(arg, "{in}", in, -1), "{out}", out, -1))
+		}
+		var res *testOutput
+		if res, err = s.run(tc, args, in, out); err != nil {
+			return "", nil, fmt.Errorf("stage %s: %v", s.name, err)
+		}
+		stageStats := parseTestOutput(tc, res.stderr)
+		for k, v := range stageStats.Counters {
+			stats.Counters[s.name+": "+k] += v
+		}
+		stats.Diagnostics = append(stats.Diagnostics, stageStats.Diagnostics...)
+		stats.Seconds += res.cpuSeconds
+		stats.WallSeconds += res.wallSeconds
+		stats.ProcessWallSeconds += res.wallSeconds
+		if res.stderr != "" {
+			stats.stderr += "== " + s.name + " ==\n" + res.stderr
+		}
+		if res.maxRSS > stats.MaxRSS {
+			stats.MaxRSS = res.maxRSS
+		}
+		stats.Extra[s.name+".seconds"] = res.cpuSeconds
+		stats.Extra[s.name+".wall"] = res.wallSeconds
+		if fi, err := os.Stat(out); err == nil {
+			stats.Extra[s.name+".bytes"] = float64(fi.Size)
+		}
+		if filepath.Ext(s.name) == ".s" {
+			var data []byte
+			if data, err = ioutil.ReadFile(out); err != nil {
+				return "", nil, fmt.Errorf("stage %s: %v", s.name, err)
+			}
+			asm = string(data)
+			stats.AsmInstrs = stageStats.AsmInstrs
+			stats.StackSpace = stageStats.StackSpace
+		}
+		in = out
+	}
+	return
+}
+
+// printStageTimes prints the total time of each stage with both toolchains
+// and, for the tests whose time regressed beyond the seconds threshold, the
+// stage whose time grew the most.
+func printStageTimes(tcs [2]*Toolchain, results []*Result, limits map[string]float64) {
+	fmt.Printf("\nStage times:\nstage\t%s\t%s\tdelta\n", tcs[0].Name, tcs[1].Name)
+	for _, s := range pipeline {
+		var totals [2]float64
+		for _, res := range results {
+			for i, st := range res.Stats {
+				totals[i] += st.Extra[s.name+".seconds"]
+			}
+		}
+		fmt.Printf("%s\t%.3f\t%.3f\t%+.2f%%\n", s.name, totals[0], totals[1], deltaPct(totals[0], totals[1]))
+	}
+
+	limit, ok := limits["seconds"]
+	if !ok {
+		return
+	}
+	header := false
+	for _, res := range results {
+		if deltaPct(res.Stats[0].Seconds, res.Stats[1].Seconds) <= limit {
+			continue
+		}
+		if !header {
+			fmt.Printf("\nStages of the compile time regressions:\ntest\tstage\t%s\t%s\n", tcs[0].Name, tcs[1].Name)
+			header = true
+		}
+		var worst *stage
+		var growth float64
+		for _, s := range pipeline {
+			key := s.name + ".seconds"
+			if d := res.Stats[1].Extra[key] - res.Stats[0].Extra[key]; worst == nil || d > growth {
+				worst, growth = s, d
+			}
+		}
+		key := worst.name + ".seconds"
+		fmt.Printf("%s\t%s\t%.3f\t%.3f\n", path.Base(res.Test), worst.name, res.Stats[0].Extra[key],
+			res.Stats[1].Extra[key])
+	}
+}
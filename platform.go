@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"exec"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// onWindows tells whether the tools are Windows executables, which have the
+// .exe suffix, and the hooks run with cmd.exe.
+var onWindows = runtime.GOOS == "windows"
+
+var onMacOS = runtime.GOOS == "darwin"
+
+// binDirs are the directories of the tools relative to the toolchain path,
+// in the order they are tried: an install prefix such as a Homebrew keg, its
+// usr, and the default toolchain of an Xcode.app or of its Developer dir.
+var binDirs = []string{
+	"bin",
+	"usr/bin",
+	"Contents/Developer/Toolchains/XcodeDefault.xctoolchain/usr/bin",
+	"Toolchains/XcodeDefault.xctoolchain/usr/bin",
+}
+
+// findBinDir returns the directory of the toolchain's tools, the first of
+// binDirs which has the compiler, or the toolchain path itself if it has the
+// compiler. It falls back to bin.
+func findBinDir(tcPath, compiler string) string {
+	for _, dir := range binDirs {
+		if _, err := os.Stat(filepath.Join(tcPath, dir, exeName(compiler))); err == nil {
+			return filepath.Join(tcPath, dir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tcPath, exeName(compiler))); err == nil {
+		return tcPath
+	}
+	return filepath.Join(tcPath, "bin")
+}
+
+// unquarantine checks the toolchain's compiler for the quarantine attribute
+// macOS puts on downloads, which makes Gatekeeper refuse to run it, and with
+// -unquarantine removes it from the whole toolchain.
+func unquarantine(tc *Toolchain) (err os.Error) {
+	compiler := tc.tool(tc.driver().Compiler())
+	if exec.Command("xattr", "-p", "com.apple.quarantine", compiler).Run() != nil {
+		return nil
+	}
+	if !*unquarantineFlag {
+		return fmt.Errorf("toolchain %s: %s is quarantined by macOS; pass -unquarantine to clear it", tc.Name, compiler)
+	}
+	if out, err := exec.Command("xattr", "-dr", "com.apple.quarantine", tc.Path).CombinedOutput(); err != nil {
+		return fmt.Errorf("toolchain %s: xattr -dr com.apple.quarantine: %v, output: %s", tc.Name, err, out)
+	}
+	return nil
+}
+
+// codesignHint explains the compiler being killed on macOS, which is what
+// happens to binaries without a valid code signature, e.g. patched ones.
+func codesignHint(err os.Error) os.Error {
+	if !onMacOS || !strings.Contains(err.String(), "signal 9") {
+		return err
+	}
+	return fmt.Errorf("%v; macOS kills binaries without a valid code signature, "+
+		"which codesign --force -s - <binary> adds", err)
+}
+
+// exeName adds the .exe suffix to a tool name on Windows.
+func exeName(name string) string {
+	if onWindows && !strings.HasSuffix(strings.ToLower(name), ".exe") {
+		return name + ".exe"
+	}
+	return name
+}
+
+// shellCommand runs a command line with /bin/sh, the arguments being $1, $2,
+// etc., or with cmd.exe on Windows, which appends the arguments to the line.
+func shellCommand(command string, args ...string) *exec.Cmd {
+	if onWindows {
+		return exec.Command("cmd", append([]string{"/C", command}, args...)...)
+	}
+	return exec.Command("/bin/sh", append([]string{"-c", command, "sh"}, args...)...)
+}
+
+// toLF turns the CRLF line endings of the Windows tools into LF, so that the
+// output parses and hashes the same as on the other systems.
+func toLF(data []byte) []byte {
+	return bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"exec"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// flagFallbacks lists what to pass instead of the llc flags which older
+// releases lack; the flags which are not listed are dropped.
+var flagFallbacks = map[string]string{
+	"-stats-json": "-stats",
+}
+
+// flagProbes holds what each toolchain's llc supports, by flag name.
+type flagProbes struct {
+	mu        sync.Mutex
+	supported map[string]bool
+}
+
+// flagName strips the value of a flag, e.g. "-mcpu=x86-64" becomes "-mcpu".
+func flagName(arg string) string {
+	if i := strings.Index(arg, "="); i >= 0 {
+		return arg[:i]
+	}
+	return arg
+}
+
+// supports reports whether the toolchain's llc accepts the flag, running
+// llc on a tiny module the first time the flag is asked about.
+func (tc *Toolchain) supports(arg string) bool {
+	tc.probes.mu.Lock()
+	defer tc.probes.mu.Unlock()
+	name := flagName(arg)
+	if ok, probed := tc.probes.supported[name]; probed {
+		return ok
+	}
+	cmd := exec.Command(tc.tool("llc"), arg, "-o", os.DevNull)
+	var errBuf bytes.Buffer
+	cmd.Stdin = bytes.NewBufferString(preflightIR)
+	cmd.Stderr = &errBuf
+	err := cmd.Run()
+	ok := err == nil || !(strings.Contains(errBuf.String(), "Unknown command line argument") ||
+		strings.Contains(errBuf.String(), "Cannot find option"))
+	if tc.probes.supported == nil {
+		tc.probes.supported = make(map[string]bool)
+	}
+	tc.probes.supported[name] = ok
+	return ok
+}
+
+// adaptFlags replaces the llc flags which the toolchain does not support by
+// their fallbacks or drops them, and records the change in Substituted.
+func (tc *Toolchain) adaptFlags(args []string) (adapted []string) {
+	for _, arg := range args {
+		if tc.supports(arg) {
+			adapted = append(adapted, arg)
+			continue
+		}
+		name := flagName(arg)
+		fallback, ok := flagFallbacks[name]
+		if ok && tc.supports(fallback) {
+			fallback += arg[len(name):]
+			adapted = append(adapted, fallback)
+		} else {
+			fallback = ""
+		}
+		tc.probes.mu.Lock()
+		if _, seen := tc.Substituted[arg]; !seen {
+			if tc.Substituted == nil {
+				tc.Substituted = make(map[string]string)
+			}
+			tc.Substituted[arg] = fallback
+			if fallback == "" {
+				log.Printf("%s: llc does not support %s, dropping it", tc.Name, arg)
+			} else {
+				log.Printf("%s: llc does not support %s, passing %s instead", tc.Name, arg, fallback)
+			}
+		}
+		tc.probes.mu.Unlock()
+	}
+	return
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+func reducePath(test, suffix string) string {
+	return path.Join(*reduceDir, artifactName(test)+suffix)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func llcCommand(tc *Toolchain) string {
+	cmd := []string{shellQuote(tc.tool("llc"))}
+	for _, arg := range tc.adaptFlags(append(append([]string(nil), llcArgs...), strings.Fields(*llcFlags)...)) {
+		cmd = append(cmd, shellQuote(arg))
+	}
+	return strings.Join(cmd, " ")
+}
+
+// crashScript is interesting if only the toolchain bad fails on the input.
+func crashScript(bad, good *Toolchain) string {
+	return fmt.Sprintf(`#!/bin/sh
+%s < "$1" > /dev/null 2>&1 && exit 1
+%s < "$1" > /dev/null 2>&1
+`, llcCommand(bad), llcCommand(good))
+}
+
+// divergenceScript is interesting if both toolchains compile the input and
+// their assemblies differ.
+func divergenceScript(tcs [2]*Toolchain) string {
+	return fmt.Sprintf(`#!/bin/sh
+a=$(mktemp) && b=$(mktemp) || exit 1
+trap 'rm -f "$a" "$b"' EXIT
+%s < "$1" > "$a" 2> /dev/null || exit 1
+%s < "$1" > "$b" 2> /dev/null || exit 1
+! cmp -s "$a" "$b"
+`, llcCommand(tcs[0]), llcCommand(tcs[1]))
+}
+
+// reduceTest runs llvm-reduce on the test with the given interestingness
+// script and returns the path to the reduced module. The input is prepared
+// for tc, whose llvm-reduce is used.
+func reduceTest(tc *Toolchain, test, script string) (reduced string, err os.Error) {
+	if err = os.MkdirAll(*reduceDir, 0755); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = prepareInput(tc, test); err != nil {
+		return
+	}
+	input := reducePath(test, ".input.bc")
+	if err = ioutil.WriteFile(input, data, 0644); err != nil {
+		return
+	}
+	scriptFile := reducePath(test, ".interesting.sh")
+	if err = ioutil.WriteFile(scriptFile, []byte(script), 0755); err != nil {
+		return
+	}
+	reduced = reducePath(test, ".reduced.ll")
+	_, err = tc.run(nil, "llvm-reduce", "--test="+scriptFile, "-o", reduced, input)
+	return
+}
+
+// reduceResults makes reproducers for the tests which crash exactly one
+// toolchain and for the tests whose metrics diverge.
+func reduceResults(tcs [2]*Toolchain, rep *report) {
+	for _, res := range rep.failed {
+		for i, status := range res.Statuses {
+			other := 1 - i
+			if status != statusCrash || res.Statuses[other] != statusOK {
+				continue
+			}
+			reduced, err := reduceWith(tcs[i], tcs[other], res.Test, crashScript(tcs[i], tcs[other]))
+			logReduced(res.Test, "crash in "+tcs[i].Name, reduced, err)
+		}
+	}
+	for _, res := range rep.results {
+		if res.diverged() {
+			reduced, err := reduceWith(tcs[0], tcs[1], res.Test, divergenceScript(tcs))
+			logReduced(res.Test, "divergence", reduced, err)
+		}
+	}
+}
+
+// reduceWith reduces the test with the llvm-reduce of the first toolchain
+// which has it.
+func reduceWith(first, second *Toolchain, test, script string) (reduced string, err os.Error) {
+	for _, tc := range []*Toolchain{first, second} {
+		if _, err = os.Stat(tc.tool("llvm-reduce")); err == nil {
+			return reduceTest(tc, test, script)
+		}
+	}
+	return "", fmt.Errorf("neither %s nor %s has llvm-reduce", first.Name, second.Name)
+}
+
+func logReduced(test, kind, reduced string, err os.Error) {
+	if err != nil {
+		log.Printf("reduceTest(%s, %s): %v", test, kind, err)
+		return
+	}
+	log.Printf("Reduced the %s in %s to %s", kind, test, reduced)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha1"
+	"exec"
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+func isRemote(test string) bool {
+	return strings.HasPrefix(test, "http://") || strings.HasPrefix(test, "https://") ||
+		strings.HasPrefix(test, "gs://")
+}
+
+// fetchTest downloads a remote test into the -download-cache directory and
+// returns its local path. The file keeps its base name, so that its
+// extension still tells its format. If -checksums declares a SHA256 sum for
+// the URL, the file must have it and the cache is keyed by the sum, so an
+// earlier download with that sum is used as is. Otherwise the cache is keyed
+// by the URL and an HTTP download is revalidated with its ETag and
+// Last-Modified; a gs:// object is downloaded again.
+func fetchTest(url string) (filename string, err os.Error) {
+	var sum string
+	if sum, err = declaredChecksum(url); err != nil {
+		return
+	}
+	var dir string
+	if sum != "" {
+		dir = path.Join(*downloadCache, "sha256", sum)
+	} else {
+		h := sha1.New()
+		io.WriteString(h, url)
+		dir = path.Join(*downloadCache, fmt.Sprintf("%x", h.Sum()))
+	}
+	filename = path.Join(dir, path.Base(url))
+	if sum != "" {
+		if _, err = os.Stat(filename); err == nil {
+			if err = verifyChecksum(filename, sum); err != nil {
+				return "", fmt.Errorf("fetchTest(%s): cached download: %v", url, err)
+			}
+			return
+		}
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	// Download next to the final name, so that an interrupted download is
+	// not taken for a cached one.
+	partial := filename + ".partial"
+	fresh := true
+	if strings.HasPrefix(url, "gs://") {
+		err = gsutilCopy(url, partial)
+	} else {
+		fresh, err = httpGet(url, filename, partial)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", fmt.Errorf("fetchTest(%s): %v", url, err)
+	}
+	if !fresh {
+		return
+	}
+	if sum != "" {
+		if err = verifyChecksum(partial, sum); err != nil {
+			os.Remove(partial)
+			return "", fmt.Errorf("fetchTest(%s): %v", url, err)
+		}
+	}
+	if err = os.Rename(partial, filename); err != nil {
+		return "", err
+	}
+	return
+}
+
+// validatorsPath is the file which keeps the ETag and Last-Modified of a
+// download, one per line.
+func validatorsPath(filename string) string {
+	return filename + ".validators"
+}
+
+// httpGet downloads the URL into partial unless the server says that the
+// earlier download in filename is still current, in which case fresh is
+// false.
+func httpGet(url, filename, partial string) (fresh bool, err os.Error) {
+	var req *http.Request
+	if req, err = http.NewRequest("GET", url, nil); err != nil {
+		return
+	}
+	if _, err := os.Stat(filename); err == nil {
+		if data, err := ioutil.ReadFile(validatorsPath(filename)); err == nil {
+			lines := strings.Split(string(data), "\n")
+			if len(lines) >= 2 {
+				if lines[0] != "" {
+					req.Header.Set("If-None-Match", lines[0])
+				}
+				if lines[1] != "" {
+					req.Header.Set("If-Modified-Since", lines[1])
+				}
+			}
+		}
+	}
+	var resp *http.Response
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("http.Get: %s", resp.Status)
+	}
+	var f *os.File
+	if f, err = os.Create(partial); err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		return
+	}
+	// Without validators, the next run downloads the test again.
+	etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	os.Remove(validatorsPath(filename))
+	if etag != "" || modified != "" {
+		ioutil.WriteFile(validatorsPath(filename), []byte(etag+"\n"+modified+"\n"), 0644)
+	}
+	return true, nil
+}
+
+// gsutilCopy downloads an object from Google Cloud Storage with gsutil.
+func gsutilCopy(url, filename string) (err os.Error) {
+	var out []byte
+	if out, err = exec.Command("gsutil", "-q", "cp", url, filename).CombinedOutput(); err != nil {
+		return fmt.Errorf("gsutil cp: %v, output: %s", err, out)
+	}
+	return
+}
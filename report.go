@@ -0,0 +1,968 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type metric struct {
+	name string
+	// timing metrics are noisy, so they are not compared for divergence.
+	timing bool
+	value  func(s *Stats) float64
+}
+
+var metrics = []metric{
+	{"asm_instrs", false, func(s *Stats) float64 { return float64(s.AsmInstrs) }},
+	{"stack", false, func(s *Stats) float64 { return float64(s.StackSpace) }},
+	{"seconds", true, func(s *Stats) float64 { return s.Seconds }},
+	{"wall", true, func(s *Stats) float64 { return s.WallSeconds }},
+	{"process_wall", true, func(s *Stats) float64 { return s.ProcessWallSeconds }},
+	{"max_rss", true, func(s *Stats) float64 { return float64(s.MaxRSS) }},
+	{"asm_bytes", false, func(s *Stats) float64 { return float64(s.AsmBytes) }},
+	{"asm_lines", false, func(s *Stats) float64 { return float64(s.AsmLines) }},
+	{"asm_gzip", false, func(s *Stats) float64 { return float64(s.AsmGzipBytes) }},
+	{"obj_gzip", false, func(s *Stats) float64 { return float64(s.ObjGzipBytes) }},
+	{"jump_tables", false, func(s *Stats) float64 { return float64(s.JumpTables) }},
+	{"jt_bytes", false, func(s *Stats) float64 { return float64(s.JumpTableBytes) }},
+	{"cp_entries", false, func(s *Stats) float64 { return float64(s.ConstPoolEntries) }},
+	{"cp_bytes", false, func(s *Stats) float64 { return float64(s.ConstPoolBytes) }},
+	{"text_bytes", false, func(s *Stats) float64 { return float64(s.TextBytes) }},
+	{"instr_bytes", false, func(s *Stats) float64 { return float64(s.InstrBytes) }},
+	{"padding", false, func(s *Stats) float64 { return float64(s.PaddingBytes) }},
+	{"unwind_bytes", false, func(s *Stats) float64 { return float64(s.UnwindBytes) }},
+	{"cfi_instrs", false, func(s *Stats) float64 { return float64(s.CFIInstrs) }},
+	{"link_seconds", true, func(s *Stats) float64 { return s.LinkSeconds }},
+	{"link_wall", true, func(s *Stats) float64 { return s.LinkWallSeconds }},
+	{"linked_bytes", false, func(s *Stats) float64 { return float64(s.LinkedBytes) }},
+	{"run_seconds", true, func(s *Stats) float64 { return s.RunSeconds }},
+	{"run_min", true, func(s *Stats) float64 { return s.RunMinSeconds }},
+	{"run_cpu", true, func(s *Stats) float64 { return s.RunCPUSeconds }},
+	{"run_stack", false, func(s *Stats) float64 { return float64(s.RunStackBytes) }},
+	{"heap_peak", false, func(s *Stats) float64 { return float64(s.RunHeapPeak) }},
+	{"heap_allocs", false, func(s *Stats) float64 { return float64(s.RunHeapAllocs) }},
+	{"startup", true, func(s *Stats) float64 { return s.StartupSeconds }},
+	{"cycles", true, func(s *Stats) float64 { return s.Cycles }},
+	{"instructions", true, func(s *Stats) float64 { return s.Instructions }},
+	{"ipc", true, func(s *Stats) float64 { return ipc(s) }},
+	{"branch_misses", true, func(s *Stats) float64 { return s.BranchMisses }},
+	{"l1d_misses", true, func(s *Stats) float64 { return s.L1DMisses }},
+
+	categoryMetric("moves", "move"),
+	categoryMetric("mem_ops", "memory"),
+	categoryMetric("branches", "branch"),
+	categoryMetric("vector_ops", "vector"),
+
+	counterMetric("spill_slots", "regalloc - Number of spill slots allocated"),
+	counterMetric("reloads", "regalloc - Number of loads added", "regalloc - Number of reloads inserted"),
+	counterMetric("spills", "regalloc - Number of stores added", "regalloc - Number of spills inserted"),
+	counterMetric("vregs_queued", "regalloc - Number of new live ranges queued"),
+	counterMetric("splits", "regalloc - Number of split global live ranges",
+		"regalloc - Number of split local live ranges"),
+	counterMetric("copies_coalesced", "regalloc - Number of copies coalesced",
+		"regcoalescing - Number of interval joins performed", "regalloc - Number of interval joins performed"),
+
+	counterMetric("sched_nodes", "pre-RA-sched - Number of nodes scheduled",
+		"machine-scheduler - Number of nodes scheduled", "misched - Number of nodes scheduled"),
+	counterMetric("sched_stalls", "post-RA-sched - Number of pipeline stalls",
+		"machine-scheduler - Number of stalls modeled", "misched - Number of stalls modeled"),
+	counterMetric("sched_noops", "post-RA-sched - Number of noops inserted"),
+	counterMetric("sched_clusters", "machine-scheduler - Number of load/store pairs clustered",
+		"misched - Number of load/store pairs clustered"),
+	// The MC counters are only collected with -mc-stats.
+	counterMetric("fixups", "assembler - Number of evaluated fixups"),
+	counterMetric("relaxed_fragments", "assembler - Number of emitted assembler fragments - relaxable",
+		"assembler - Number of relaxed instructions"),
+	counterMetric("relaxation_steps", "assembler - Number of assembler layout and relaxation steps"),
+}
+
+// ipc is the instructions per cycle of a -perf run, or 0 without cycles.
+func ipc(s *Stats) float64 {
+	if s.Cycles == 0 {
+		return 0
+	}
+	return s.Instructions / s.Cycles
+}
+
+// counterKeys maps the metrics which come from -stats counters to them.
+var counterKeys = map[string][]string{
+	"asm_instrs": {asmInstrsKey},
+	"stack":      {stackSpaceKey},
+}
+
+// counterMetric sums the given -stats counters. Listing several keys lets a
+// metric cover counters renamed between LLVM versions.
+func counterMetric(name string, keys ...string) metric {
+	counterKeys[name] = keys
+	return metric{name, false, func(s *Stats) float64 {
+		total := 0
+		for _, k := range keys {
+			total += s.Counters[k]
+		}
+		return float64(total)
+	}}
+}
+
+func categoryMetric(name, category string) metric {
+	return metric{name, false, func(s *Stats) float64 { return float64(s.Categories[category]) }}
+}
+
+// missing reports whether the metric is N/A in the stats.
+func (m metric) missing(s *Stats) bool {
+	for _, name := range s.Missing {
+		if name == m.name {
+			return true
+		}
+	}
+	return false
+}
+
+// cell formats the value of the metric in the stats, or N/A.
+func (m metric) cell(s *Stats) string {
+	if m.missing(s) {
+		return "N/A"
+	}
+	return m.format(m.value(s))
+}
+
+func (m metric) format(v float64) string {
+	if v == math.Floor(v) && math.Fabs(v) < 1e15 {
+		return strconv.Itoa64(int64(v))
+	}
+	return fmt.Sprint(v)
+}
+
+func findMetric(name string) (m metric, ok bool) {
+	for _, m = range metrics {
+		if m.name == name {
+			return m, true
+		}
+	}
+	// Collector metrics are named <collector>.<value>; stored runs may have
+	// them without the collector being registered.
+	if i := strings.Index(name, "."); i > 0 && i < len(name)-1 {
+		return extraMetric(name), true
+	}
+	return
+}
+
+type columnSet struct {
+	metrics  []metric
+	delta    bool
+	deltaPct bool
+	status   bool
+	notes    bool
+}
+
+func parseColumns(spec string) (cs *columnSet, err os.Error) {
+	cs = new(columnSet)
+	for _, name := range strings.Split(spec, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case "delta":
+			cs.delta = true
+		case "delta_pct":
+			cs.deltaPct = true
+		case "status":
+			cs.status = true
+		default:
+			m, ok := findMetric(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown column %q", name)
+			}
+			cs.metrics = append(cs.metrics, m)
+		}
+	}
+	if len(cs.metrics) == 0 {
+		return nil, fmt.Errorf("no metrics selected in %q", spec)
+	}
+	return
+}
+
+var exitLevels = map[string]int{
+	"never":      0,
+	"failure":    1,
+	"regression": 2,
+	"divergence": 3,
+}
+
+const (
+	statusOK           = "OK"
+	statusParseFail    = "PARSE_FAIL"
+	statusIncompatible = "INCOMPATIBLE"
+	statusFail         = "FAIL"
+	statusCrash        = "CRASH"
+	statusTimeout      = "TIMEOUT"
+	// statusRunCrash is the failure of the executable of a test with -run.
+	statusRunCrash = "RUN_CRASH"
+)
+
+type Result struct {
+	Test      string
+	Stats     [2]*Stats
+	Note      string
+	Identical bool
+	DiffFile  string
+	// SideBySideFile is the HTML page with both assemblies side by side.
+	SideBySideFile string
+	// FunctionDiffs lists the functions which differ, with -function-diff.
+	FunctionDiffs []funcDiff
+	Err           os.Error
+	// Statuses holds the outcome of the test with each toolchain: OK, FAIL,
+	// CRASH, TIMEOUT, PARSE_FAIL, INCOMPATIBLE, VETOED or RUN_CRASH.
+	Statuses [2]string
+	// Errors describes the failure with each toolchain, nil for the toolchains
+	// with which the test passed. It is nil if the test passed with both.
+	Errors []*ErrorInfo
+	// Crash is set if llc crashed on the test.
+	Crash *Crash
+	// InputBytes is the size of the test input.
+	InputBytes int64
+	// Aliases lists the tests with the same contents, which did not run.
+	Aliases []string
+	// Throttled is set if the CPUs were throttled while the test ran.
+	Throttled bool
+	// OutputMismatch is the first difference between the outputs of the
+	// executables with -check-output.
+	OutputMismatch string
+	cached         bool
+	// started and finished tell when the test ran, in nanoseconds.
+	started, finished int64
+}
+
+// incompatible reports whether the test did not run only because its
+// bitcode is too new for one of the toolchains.
+func (r *Result) incompatible() bool {
+	return (r.Statuses[0] == statusIncompatible && r.Statuses[1] == statusOK) ||
+		(r.Statuses[0] == statusOK && r.Statuses[1] == statusIncompatible)
+}
+
+// vetoed reports whether a hook dropped the test.
+func (r *Result) vetoed() bool {
+	return r.Statuses[0] == statusVetoed || r.Statuses[1] == statusVetoed
+}
+
+// status returns the pair of statuses if the test did not pass with both
+// toolchains, as with -crash-only, or whether the outputs are identical.
+func (r *Result) status() string {
+	failed := r.Statuses[0] != "" && r.Statuses[1] != "" &&
+		(r.Statuses[0] != statusOK || r.Statuses[1] != statusOK)
+	if r.Err != nil || failed {
+		return r.Statuses[0] + "/" + r.Statuses[1]
+	}
+	if r.Identical {
+		return "IDENTICAL"
+	}
+	return "DIFFERENT"
+}
+
+// loadNotes reads a side file of per-test annotations. Each line has the form
+// "<test>: <note>", where <test> is either the path or the base name of the
+// test. Empty lines and lines starting with '#' are ignored.
+func loadNotes(filename string) (notes map[string]string, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	notes = make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		kv := strings.SplitN(line, ": ", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<test>: <note>\"", filename, i+1)
+		}
+		test, note := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if prev, ok := notes[test]; ok {
+			note = prev + "; " + note
+		}
+		notes[test] = note
+	}
+	return
+}
+
+func (r *report) noteFor(test string) string {
+	if note, ok := r.notes[test]; ok {
+		return note
+	}
+	return r.notes[path.Base(test)]
+}
+
+func deltaPct(v1, v2 float64) float64 {
+	if v1 == v2 {
+		return 0
+	}
+	if v1 == 0 {
+		return math.Inf(1)
+	}
+	return (v2 - v1) / v1 * 100
+}
+
+func parseThresholds(spec string) (res map[string]float64, err os.Error) {
+	res = make(map[string]float64)
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("threshold %q is not in metric=percent form", item)
+		}
+		if res[kv[0]], err = strconv.Atof64(kv[1]); err != nil {
+			return nil, fmt.Errorf("threshold %q: %v", item, err)
+		}
+	}
+	return
+}
+
+// severity returns the largest delta among the metrics which exceed
+// their thresholds, or 0 if the test did not regress.
+func (r *Result) severity(limits map[string]float64) (worst float64) {
+	for _, m := range metrics {
+		limit, ok := limits[m.name]
+		if !ok {
+			continue
+		}
+		if m.missing(r.Stats[0]) || m.missing(r.Stats[1]) {
+			continue
+		}
+		if d := deltaPct(m.value(r.Stats[0]), m.value(r.Stats[1])); d > limit && d > worst {
+			worst = d
+		}
+	}
+	return
+}
+
+// diverged reports whether any of the non-timing metrics differ.
+func (r *Result) diverged() bool {
+	for _, m := range metrics {
+		if m.missing(r.Stats[0]) || m.missing(r.Stats[1]) {
+			continue
+		}
+		if !m.timing && m.value(r.Stats[0]) != m.value(r.Stats[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+type bySeverity struct {
+	results []*Result
+	limits  map[string]float64
+}
+
+func (s bySeverity) Len() int      { return len(s.results) }
+func (s bySeverity) Swap(i, j int) { s.results[i], s.results[j] = s.results[j], s.results[i] }
+func (s bySeverity) Less(i, j int) bool {
+	return s.results[i].severity(s.limits) > s.results[j].severity(s.limits)
+}
+
+type byDelta struct {
+	results []*Result
+	m       metric
+	desc    bool
+}
+
+func (s byDelta) Len() int      { return len(s.results) }
+func (s byDelta) Swap(i, j int) { s.results[i], s.results[j] = s.results[j], s.results[i] }
+func (s byDelta) Less(i, j int) bool {
+	di := deltaPct(s.m.value(s.results[i].Stats[0]), s.m.value(s.results[i].Stats[1]))
+	dj := deltaPct(s.m.value(s.results[j].Stats[0]), s.m.value(s.results[j].Stats[1]))
+	if s.desc {
+		return di > dj
+	}
+	return di < dj
+}
+
+func parseSort(spec string) (s *byDelta, err os.Error) {
+	parts := strings.SplitN(spec, ":", 2)
+	s = &byDelta{desc: true}
+	var ok bool
+	if s.m, ok = findMetric(parts[0]); !ok {
+		return nil, fmt.Errorf("unknown metric %q in sort spec %q", parts[0], spec)
+	}
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "asc":
+			s.desc = false
+		case "desc":
+		default:
+			return nil, fmt.Errorf("unknown sort order %q in sort spec %q", parts[1], spec)
+		}
+	}
+	return
+}
+
+func printHeader(tcs [2]*Toolchain, cs *columnSet) {
+	cells := []string{"test"}
+	for _, tc := range tcs {
+		for _, m := range cs.metrics {
+			cells = append(cells, tc.Name+"."+m.name)
+		}
+	}
+	for _, m := range cs.metrics {
+		if cs.delta {
+			cells = append(cells, m.name+".delta")
+		}
+		if cs.deltaPct {
+			cells = append(cells, m.name+".delta_pct")
+		}
+	}
+	if cs.status {
+		cells = append(cells, "status")
+	}
+	if cs.notes {
+		cells = append(cells, "note")
+	}
+	fmt.Println(strings.Join(cells, "\t"))
+}
+
+func printStats(res *Result, cs *columnSet) (err os.Error) {
+	cells := []string{path.Base(res.Test)}
+	for _, s := range res.Stats {
+		for _, m := range cs.metrics {
+			cells = append(cells, m.cell(s))
+		}
+	}
+	for _, m := range cs.metrics {
+		v1, v2 := m.value(res.Stats[0]), m.value(res.Stats[1])
+		if m.missing(res.Stats[0]) || m.missing(res.Stats[1]) {
+			if cs.delta {
+				cells = append(cells, "N/A")
+			}
+			if cs.deltaPct {
+				cells = append(cells, "N/A")
+			}
+			continue
+		}
+		if cs.delta {
+			cells = append(cells, m.format(v2-v1))
+		}
+		if cs.deltaPct {
+			cells = append(cells, fmt.Sprintf("%.2f", deltaPct(v1, v2)))
+		}
+	}
+	if cs.status {
+		cells = append(cells, res.status())
+	}
+	if cs.notes {
+		cells = append(cells, res.Note)
+	}
+	_, err = fmt.Println(strings.Join(cells, "\t"))
+	return
+}
+
+func printFailures(tcs [2]*Toolchain, failed []*Result) {
+	fmt.Printf("\n%d tests did not run:\ntest\t%s\t%s\terror\n", len(failed), tcs[0].Name, tcs[1].Name)
+	for _, res := range failed {
+		statuses := res.Statuses
+		if statuses[0] == "" {
+			statuses = [2]string{"?", "?"}
+		}
+		fmt.Printf("%s\t%s\t%s\t%v\n", path.Base(res.Test), statuses[0], statuses[1], res.Err)
+	}
+}
+
+// statusMatrix counts the tests by whether they failed with the first and
+// with the second toolchain.
+func statusMatrix(results []*Result) (m [2][2]int) {
+	for _, res := range results {
+		var failed [2]int
+		for i, status := range res.Statuses {
+			if status != statusOK {
+				failed[i] = 1
+			}
+		}
+		m[failed[0]][failed[1]]++
+	}
+	return
+}
+
+func printStatusMatrix(tcs [2]*Toolchain, m [2][2]int) {
+	fmt.Printf("\nStatus matrix:\n\t%s pass\t%s fail\n", tcs[1].Name, tcs[1].Name)
+	for i, outcome := range []string{"pass", "fail"} {
+		fmt.Printf("%s %s\t%d\t%d\n", tcs[0].Name, outcome, m[i][0], m[i][1])
+	}
+}
+
+func printFunctionDeltas(tcs [2]*Toolchain, results []*Result) {
+	for _, res := range results {
+		deltas := functionDeltas(res.Stats, *perFunction)
+		if len(deltas) == 0 {
+			continue
+		}
+		fmt.Printf("\nFunctions with the biggest instruction count changes in %s:\n", path.Base(res.Test))
+		fmt.Printf("function\t%s\t%s\tdelta\n", tcs[0].Name, tcs[1].Name)
+		for _, d := range deltas {
+			fmt.Printf("%s\t%d\t%d\t%+d\n", d.Name, d.Counts[0], d.Counts[1], d.delta())
+		}
+	}
+}
+
+func printFunctionDiffs(tcs [2]*Toolchain, results []*Result) {
+	for _, res := range results {
+		if len(res.FunctionDiffs) == 0 {
+			continue
+		}
+		fmt.Printf("\nFunctions which differ in %s:\n", path.Base(res.Test))
+		fmt.Printf("function\tchanged\t%s\t%s\n", tcs[0].Name, tcs[1].Name)
+		for _, d := range res.FunctionDiffs {
+			fmt.Printf("%s\t%d\t%d\t%d\n", d.Name, d.Changed, d.Counts[0], d.Counts[1])
+		}
+	}
+}
+
+func printCategoryDeltas(results []*Result) {
+	fmt.Printf("\nInstruction category deltas:\ntest")
+	for _, c := range instrCategories {
+		fmt.Printf("\t%s", c)
+	}
+	fmt.Println()
+	for _, res := range results {
+		if res.Identical {
+			continue
+		}
+		fmt.Print(path.Base(res.Test))
+		for _, c := range instrCategories {
+			fmt.Printf("\t%+d", res.Stats[1].Categories[c]-res.Stats[0].Categories[c])
+		}
+		fmt.Println()
+	}
+}
+
+type passDelta struct {
+	Name  string
+	Times [2]float64
+}
+
+type byAbsPassDelta []passDelta
+
+func (s byAbsPassDelta) Len() int      { return len(s) }
+func (s byAbsPassDelta) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byAbsPassDelta) Less(i, j int) bool {
+	return math.Fabs(s[i].Times[1]-s[i].Times[0]) > math.Fabs(s[j].Times[1]-s[j].Times[0])
+}
+
+// passDeltas returns at most n passes whose wall times differ the most.
+func passDeltas(stats [2]*Stats, n int) []passDelta {
+	all := make(map[string]*passDelta)
+	for i, s := range stats {
+		for name, t := range s.PassTimes {
+			d, ok := all[name]
+			if !ok {
+				d = &passDelta{Name: name}
+				all[name] = d
+			}
+			d.Times[i] = t
+		}
+	}
+	var res []passDelta
+	for _, d := range all {
+		res = append(res, *d)
+	}
+	sort.Sort(byAbsPassDelta(res))
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+type report struct {
+	tcs       [2]*Toolchain
+	limits    map[string]float64
+	cs        *columnSet
+	order     *byDelta
+	exitLevel int
+	worst     int
+	notes     map[string]string
+	results   []*Result
+	failed    []*Result
+	// watched holds the tests which already counted for -fail-fast and
+	// -max-failures.
+	watched  map[string]bool
+	failures int
+	// aliases maps the tests to their duplicates, with -dedup.
+	aliases map[string][]string
+	// tolerances holds the per-test epsilons of -output-tolerances.
+	tolerances map[string]tolerance
+	// warnedMissing holds the toolchain and metric pairs already warned
+	// about by markMissing.
+	warnedMissing map[string]bool
+	// printed holds the -stats counters each toolchain printed in the run.
+	printed [2]map[string]bool
+	// xfail holds the tests whose divergence is expected, with -xfail.
+	xfail map[string]string
+	// tui shows the results as they come, with -tui.
+	tui *tui
+}
+
+// newReport builds a report configured by the command-line flags.
+func newReport(tcs [2]*Toolchain) (r *report, err os.Error) {
+	r = &report{tcs: tcs, watched: make(map[string]bool), warnedMissing: make(map[string]bool),
+		printed: [2]map[string]bool{make(map[string]bool), make(map[string]bool)}}
+	if r.limits, err = parseThresholds(*thresholds); err != nil {
+		return nil, fmt.Errorf("parseThresholds: %v", err)
+	}
+	if r.cs, err = parseColumns(*columns); err != nil {
+		return nil, fmt.Errorf("parseColumns: %v", err)
+	}
+	if *sortBy != "" {
+		if r.order, err = parseSort(*sortBy); err != nil {
+			return nil, fmt.Errorf("parseSort: %v", err)
+		}
+	}
+	if *notesFile != "" {
+		if r.notes, err = loadNotes(*notesFile); err != nil {
+			return nil, fmt.Errorf("loadNotes: %v", err)
+		}
+		r.cs.notes = true
+	}
+	if *tolerancesFile != "" {
+		if r.tolerances, err = loadTolerances(*tolerancesFile, tolerance{*outputAbsEpsilon, *outputRelEpsilon}); err != nil {
+			return nil, fmt.Errorf("loadTolerances: %v", err)
+		}
+	}
+	if r.xfail, err = loadTestList(*xfailFile); err != nil {
+		return
+	}
+	var ok bool
+	if r.exitLevel, ok = exitLevels[*exitOn]; !ok {
+		return nil, fmt.Errorf("Unknown -exit-on value: %s", *exitOn)
+	}
+	if !r.buffered() {
+		printHeader(tcs, r.cs)
+	}
+	return
+}
+
+func (r *report) buffered() bool {
+	return *onlyRegressions || r.order != nil || *tuiFlag
+}
+
+func (r *report) record(event string) {
+	if level := exitLevels[event]; r.worst == 0 || level < r.worst {
+		r.worst = level
+	}
+}
+
+// markMissing sets the Missing metrics of the stats of a test for which one
+// toolchain printed no -stats counters at all while the other did. LLVM does
+// not print zero counters, so a counter absent from a single test is 0, not
+// N/A; markNeverPrinted handles the counters absent from the whole run.
+func (r *report) markMissing(res *Result) {
+	for i, s := range res.Stats {
+		s.Missing = nil
+		for k := range s.Counters {
+			r.printed[i][k] = true
+		}
+	}
+	for i, s := range res.Stats {
+		if len(s.Counters) > 0 || len(res.Stats[1-i].Counters) == 0 {
+			continue
+		}
+		for _, m := range metrics {
+			if _, ok := counterKeys[m.name]; ok {
+				s.Missing = append(s.Missing, m.name)
+			}
+		}
+	}
+}
+
+// markNeverPrinted sets the counter metrics which one toolchain printed in
+// none of the tests of the run while the other did as Missing, which is
+// usually a counter renamed or removed in one release. It reports whether it
+// marked any.
+func (r *report) markNeverPrinted() (marked bool) {
+	for _, m := range metrics {
+		keys, ok := counterKeys[m.name]
+		if !ok {
+			continue
+		}
+		var found [2]bool
+		for i := range found {
+			for _, k := range keys {
+				found[i] = found[i] || r.printed[i][k]
+			}
+		}
+		if found[0] == found[1] {
+			continue
+		}
+		i := 0
+		if found[0] {
+			i = 1
+		}
+		for _, res := range r.results {
+			if !m.missing(res.Stats[i]) {
+				res.Stats[i].Missing = append(res.Stats[i].Missing, m.name)
+				marked = true
+			}
+		}
+		if key := r.tcs[i].Name + "\x00" + m.name; !r.warnedMissing[key] {
+			r.warnedMissing[key] = true
+			log.Printf("WARNING: %s did not print the counters of %s in any test, which %s did; it is reported as N/A",
+				r.tcs[i].Name, m.name, r.tcs[1-i].Name)
+		}
+	}
+	return
+}
+
+// classify records the -exit-on events of a test which ran with both
+// toolchains.
+func (r *report) classify(res *Result) {
+	if res.severity(r.limits) > 0 {
+		r.record("regression")
+	}
+	if res.diverged() && !listed(r.xfail, res.Test) {
+		r.record("divergence")
+	}
+	if res.goldenMismatch() || res.OutputMismatch != "" {
+		r.record("failure")
+	}
+}
+
+// reclassify records the -exit-on events of every test again, once
+// markNeverPrinted changed what is compared.
+func (r *report) reclassify() {
+	r.worst = 0
+	for _, res := range r.failed {
+		if !res.incompatible() && !res.vetoed() {
+			r.record("failure")
+		}
+	}
+	for _, res := range r.results {
+		r.classify(res)
+	}
+}
+
+func (r *report) add(res *Result) {
+	if r.tui != nil {
+		defer r.tui.add(res)
+	}
+	if aliases, ok := r.aliases[res.Test]; ok {
+		res.Aliases = aliases
+	}
+	r.watch(res)
+	if res.Err != nil {
+		log.Printf("%s: %v", res.Test, res.Err)
+		if !res.incompatible() && !res.vetoed() {
+			r.record("failure")
+		}
+		r.failed = append(r.failed, res)
+		return
+	}
+	res.Statuses = [2]string{statusOK, statusOK}
+	res.Note = r.noteFor(res.Test)
+	r.markMissing(res)
+	if res.InputBytes == 0 {
+		if fi, err := os.Stat(res.Test); err == nil {
+			res.InputBytes = fi.Size
+		}
+	}
+	r.checkOutputs(res)
+	r.classify(res)
+	r.results = append(r.results, res)
+	if !r.buffered() {
+		if err := printStats(res, r.cs); err != nil {
+			log.Fatalf("printStats: %v", err)
+		}
+	}
+}
+
+// finish writes the report and exits with the status selected by -exit-on.
+func (r *report) finish(run *Run) {
+	r.write(run)
+	if r.failing() {
+		os.Exit(1)
+	}
+}
+
+// watch stops the batch at the first failure or regression with -fail-fast,
+// printing what went wrong, or once -max-failures tests failed. A test may be
+// watched more than once, e.g. in both phases of -two-phase.
+func (r *report) watch(res *Result) {
+	if r.watched[res.Test] {
+		return
+	}
+	failed := res.Err != nil && !res.incompatible() && !res.vetoed()
+	regressed := res.Err == nil && (res.severity(r.limits) > 0 || res.goldenMismatch())
+	if !failed && !regressed {
+		return
+	}
+	r.watched[res.Test] = true
+	if failed {
+		r.failures++
+	}
+	switch {
+	case *failFast:
+		stopBatch()
+		r.printDiagnostics(res)
+	case *maxFailures > 0 && r.failures == *maxFailures:
+		stopBatch()
+		log.Printf("Stopping after %d failed tests", r.failures)
+	}
+}
+
+func (r *report) printDiagnostics(res *Result) {
+	fmt.Printf("\n-fail-fast: stopping at %s\n", res.Test)
+	r.printDetails(os.Stdout, res)
+}
+
+// printDetails prints why the test failed, or the metrics which differ.
+func (r *report) printDetails(w io.Writer, res *Result) {
+	if res.Err != nil {
+		fmt.Fprintf(w, "error: %v\n", res.Err)
+		for i, info := range res.Errors {
+			if info != nil {
+				fmt.Fprintf(w, "%s: %s in %s, exit code %d\n%s\n", r.tcs[i].Name, info.Classification, info.Phase,
+					info.ExitCode, info.Stderr)
+			}
+		}
+		if res.Crash != nil {
+			fmt.Fprintf(w, "crash signature: %s\n", res.Crash.Signature)
+		}
+		return
+	}
+	fmt.Fprintf(w, "metric\t%s\t%s\tdelta\n", r.tcs[0].Name, r.tcs[1].Name)
+	for _, m := range metrics {
+		v1, v2 := m.value(res.Stats[0]), m.value(res.Stats[1])
+		if m.missing(res.Stats[0]) || m.missing(res.Stats[1]) {
+			fmt.Fprintf(w, "%s\t%s\t%s\tN/A\n", m.name, m.cell(res.Stats[0]), m.cell(res.Stats[1]))
+			continue
+		}
+		if v1 == v2 {
+			continue
+		}
+		d := deltaPct(v1, v2)
+		mark := ""
+		if limit, ok := r.limits[m.name]; ok && d > limit {
+			mark = "\tover the threshold"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%+.2f%%%s\n", m.name, m.format(v1), m.format(v2), d, mark)
+	}
+	if res.goldenMismatch() {
+		fmt.Fprintf(w, "the assembly does not match the golden snapshot\n")
+	}
+	if res.DiffFile != "" {
+		fmt.Fprintf(w, "asm diff: %s\n", res.DiffFile)
+	}
+}
+
+func (r *report) failing() bool {
+	return r.worst > 0 && r.worst <= r.exitLevel
+}
+
+// write prints the buffered table and writes the requested output files. run
+// may be nil if the results do not come from a live run.
+func (r *report) write(run *Run) {
+	var err os.Error
+	if r.markNeverPrinted() {
+		r.reclassify()
+	}
+	if r.order != nil {
+		r.order.results = r.results
+		sort.Sort(r.order)
+	}
+	if r.buffered() {
+		var shown []*Result
+		for _, res := range r.results {
+			if !*onlyRegressions || res.severity(r.limits) > 0 {
+				shown = append(shown, res)
+			}
+		}
+		// -tui only buffers the table until the session ends; the order
+		// is the same as without it.
+		if r.order == nil && *onlyRegressions {
+			sort.Sort(bySeverity{shown, r.limits})
+		}
+		printHeader(r.tcs, r.cs)
+		for _, res := range shown {
+			if err = printStats(res, r.cs); err != nil {
+				log.Fatalf("printStats: %v", err)
+			}
+		}
+	}
+	if batchStopped() {
+		fmt.Printf("\nThe run was stopped early, the report only covers the tests which ran.\n")
+	}
+	if run != nil && run.Labels[timingWarningLabel] != "" {
+		fmt.Printf("\nThe timings may be unstable: %s.\n", run.Labels[timingWarningLabel])
+	}
+	all := append(append([]*Result(nil), r.results...), r.failed...)
+	matrix := statusMatrix(all)
+	printStatusMatrix(r.tcs, matrix)
+	if len(r.failed) > 0 {
+		printFailures(r.tcs, r.failed)
+		if buckets := bucketCrashes(r.failed); len(buckets) > 0 {
+			printCrashBuckets(buckets)
+		}
+	}
+	if *sizeBuckets != "" {
+		buckets, err := bucketResults(*sizeBuckets, r.cs.metrics, r.results)
+		if err != nil {
+			log.Fatalf("bucketResults: %v", err)
+		}
+		printBuckets(r.cs.metrics, buckets)
+	}
+	if *perFunction > 0 {
+		printFunctionDeltas(r.tcs, r.results)
+	}
+	if *functionDiff {
+		printFunctionDiffs(r.tcs, r.results)
+	}
+	if *sectionSizesFlag {
+		printSectionDeltas(r.tcs, r.results)
+	}
+	if *symbolDiff > 0 {
+		printSymbolDiffs(r.tcs, r.results)
+	}
+	if *categories {
+		printCategoryDeltas(r.results)
+	}
+	if *goldenDir != "" {
+		printGoldenMismatches(r.tcs, r.results)
+	}
+	if *checkOutput {
+		printOutputMismatches(r.results)
+	}
+	if pipeline != nil {
+		printStageTimes(r.tcs, r.results, r.limits)
+	}
+	if *diagnosticsLimit > 0 {
+		printDiagnostics(r.tcs, r.results)
+	}
+	printThrottled(all, r.limits)
+	printAliases(all)
+	if *resultsOut != "" && run != nil {
+		if err = writeResults(*resultsOut, *appendResults, run, all); err != nil {
+			log.Fatalf("writeResults: %v", err)
+		}
+	}
+	if *htmlOut != "" {
+		if err = writeHTMLReport(*htmlOut, r.tcs, r.cs, r.limits, r.results, matrix); err != nil {
+			log.Fatalf("writeHTMLReport: %v", err)
+		}
+	}
+	if *reportTemplate != "" {
+		data := &templateData{Run: run, Toolchains: r.tcs, Results: r.results, Failed: r.failed, Matrix: matrix}
+		for _, m := range r.cs.metrics {
+			data.Metrics = append(data.Metrics, m.name)
+		}
+		if err = writeTemplateReport(*reportTemplate, *templateOut, data); err != nil {
+			log.Fatalf("writeTemplateReport: %v", err)
+		}
+	}
+	if *bundleOut != "" && run != nil {
+		if err = writeBundle(*bundleOut, run, all); err != nil {
+			log.Fatalf("writeBundle: %v", err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"csv"
+	"flag"
+	"fmt"
+	"io"
+	"json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	var items []string
+	for k, v := range l {
+		items = append(items, k+"="+v)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func (l labelFlag) Set(s string) bool {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return false
+	}
+	l[kv[0]] = kv[1]
+	return true
+}
+
+var labels = make(labelFlag)
+
+func init() {
+	flag.Var(labels, "label", "Attach key=value metadata to the run; may be repeated")
+}
+
+type Run struct {
+	ID         string
+	Timestamp  string
+	Toolchains [2]*Toolchain
+	Labels     map[string]string
+}
+
+func newRun(tcs [2]*Toolchain) *Run {
+	return &Run{
+		ID:         fmt.Sprintf("%s-%d", time.LocalTime().Format("20060102-150405"), os.Getpid()),
+		Timestamp:  time.UTC().Format(time.RFC3339),
+		Toolchains: tcs,
+		Labels:     labels,
+	}
+}
+
+// Record is a single line of a JSON results file.
+type Record struct {
+	RunID          string            `json:"run_id"`
+	Timestamp      string            `json:"timestamp"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Test           string            `json:"test"`
+	InputBytes     int64             `json:"input_bytes,omitempty"`
+	Toolchains     [2]string         `json:"toolchains"`
+	Statuses       [2]string         `json:"statuses,omitempty"`
+	Errors         []*ErrorInfo      `json:"errors,omitempty"`
+	Stats          [2]*Stats         `json:"stats"`
+	Note           string            `json:"note,omitempty"`
+	Identical      bool              `json:"identical,omitempty"`
+	Aliases        []string          `json:"aliases,omitempty"`
+	Throttled      bool              `json:"throttled,omitempty"`
+	OutputMismatch string            `json:"output_mismatch,omitempty"`
+}
+
+func (run *Run) record(res *Result) *Record {
+	return &Record{
+		RunID:          run.ID,
+		Timestamp:      run.Timestamp,
+		Labels:         run.Labels,
+		Test:           res.Test,
+		InputBytes:     res.InputBytes,
+		Toolchains:     [2]string{run.Toolchains[0].Name, run.Toolchains[1].Name},
+		Statuses:       res.Statuses,
+		Errors:         res.Errors,
+		Stats:          res.Stats,
+		Note:           res.Note,
+		Identical:      res.Identical,
+		Aliases:        res.Aliases,
+		Throttled:      res.Throttled,
+		OutputMismatch: res.OutputMismatch,
+	}
+}
+
+func writeResults(filename string, appendMode bool, run *Run, results []*Result) (err os.Error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	var f *os.File
+	if f, err = os.OpenFile(filename, flags, 0644); err != nil {
+		return
+	}
+	defer f.Close()
+
+	switch filepath.Ext(filename) {
+	case ".json":
+		return writeJSONResults(f, run, results)
+	case ".csv":
+		var fi *os.FileInfo
+		if fi, err = f.Stat(); err != nil {
+			return
+		}
+		return writeCSVResults(f, fi.Size == 0, run, results)
+	}
+	return fmt.Errorf("unknown results format for %s, expected .json or .csv", filename)
+}
+
+func writeJSONResults(w io.Writer, run *Run, results []*Result) (err os.Error) {
+	enc := json.NewEncoder(w)
+	for _, res := range results {
+		if err = enc.Encode(run.record(res)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func writeCSVResults(w io.Writer, header bool, run *Run, results []*Result) (err os.Error) {
+	cw := csv.NewWriter(w)
+	if header {
+		row := []string{"run_id", "timestamp", "labels", "test", "status", "toolchain1", "toolchain2",
+			"t1.status", "t2.status", "t1.error_phase", "t1.exit_code", "t1.error", "t2.error_phase", "t2.exit_code",
+			"t2.error"}
+		for i := range run.Toolchains {
+			for _, m := range metrics {
+				row = append(row, fmt.Sprintf("t%d.%s", i+1, m.name))
+			}
+		}
+		if err = cw.Write(row); err != nil {
+			return
+		}
+	}
+	for _, res := range results {
+		row := []string{run.ID, run.Timestamp, labelFlag(run.Labels).String(), res.Test, res.status(),
+			run.Toolchains[0].Name, run.Toolchains[1].Name, res.Statuses[0], res.Statuses[1]}
+		for i := range run.Toolchains {
+			if i < len(res.Errors) && res.Errors[i] != nil {
+				info := res.Errors[i]
+				row = append(row, info.Phase, strconv.Itoa(info.ExitCode), info.Message)
+			} else {
+				row = append(row, "", "", "")
+			}
+		}
+		for _, s := range res.Stats {
+			for _, m := range metrics {
+				if s == nil {
+					row = append(row, "")
+				} else {
+					row = append(row, m.cell(s))
+				}
+			}
+		}
+		if err = cw.Write(row); err != nil {
+			return
+		}
+	}
+	cw.Flush()
+	return
+}
+
+func readRecords(filename string) (records []*Record, err os.Error) {
+	var f *os.File
+	if f, err = os.Open(filename); err != nil {
+		return
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		rec := new(Record)
+		if err = dec.Decode(rec); err == os.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		records = append(records, rec)
+	}
+	panic("unreachable")
+}
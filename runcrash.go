@@ -0,0 +1,131 @@
+package main
+
+import (
+	"debug/elf"
+	"exec"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// runtimeCrash is returned when an executable of -run dies from a signal or
+// exits with an error, which usually means it was miscompiled.
+type runtimeCrash struct {
+	iteration int
+	msg       *os.Waitmsg
+	stderr    string
+	// backtrace holds the symbolized frames of the crash, if gdb could
+	// reproduce it.
+	backtrace string
+}
+
+func (e *runtimeCrash) String() string {
+	s := fmt.Sprintf("the executable failed in iteration %d: %v", e.iteration, e.msg)
+	if e.backtrace != "" {
+		s += "\n" + e.backtrace
+	}
+	return s
+}
+
+var (
+	gdbFrameRegexp   = regexp.MustCompile(`^#[0-9]+ +(0x[0-9a-f]+) in `)
+	gdbMappingRegexp = regexp.MustCompile(`^ *(0x[0-9a-f]+) +0x[0-9a-f]+ +0x[0-9a-f]+ +0x[0-9a-f]+ +(/.*)$`)
+)
+
+// crashBacktrace reruns a crashing executable under gdb and symbolizes the
+// frames in the executable with the toolchain's llvm-symbolizer, which also
+// tells the inlined frames. The other frames keep the names gdb gives them.
+func crashBacktrace(tc *Toolchain, exeFile string, spec *runSpec) (backtrace string, err os.Error) {
+	args := []string{"-batch", "-nx"}
+	for _, kv := range spec.Env {
+		args = append(args, "-ex", "set environment "+kv)
+	}
+	run := "run"
+	if spec.Stdin != "" {
+		run += " < " + shellQuote(spec.Stdin)
+	}
+	args = append(args, "-ex", run, "-ex", "bt", "-ex", "info proc mappings", "--args", exeFile)
+	var out []byte
+	out, _ = exec.Command("gdb", append(args, spec.Args...)...).CombinedOutput()
+	lines := strings.Split(string(out), "\n")
+	// A position-independent executable is symbolized by its offsets from
+	// where it was loaded.
+	base := uint64(0)
+	if f, err := elf.Open(exeFile); err == nil {
+		if f.Type == elf.ET_DYN {
+			for _, line := range lines {
+				if ss := gdbMappingRegexp.FindStringSubmatch(line); ss != nil && ss[2] == exeFile {
+					base, _ = strconv.Btoui64(ss[1][2:], 16)
+					break
+				}
+			}
+		}
+		f.Close()
+	}
+	var frames []string
+	for _, line := range lines {
+		ss := gdbFrameRegexp.FindStringSubmatch(line)
+		if ss == nil {
+			if strings.HasPrefix(line, "#") {
+				frames = append(frames, line)
+			}
+			continue
+		}
+		pc, _ := strconv.Btoui64(ss[1][2:], 16)
+		sym, err := tc.run(nil, "llvm-symbolizer", "--obj="+exeFile, fmt.Sprintf("0x%x", pc-base))
+		if err != nil || strings.HasPrefix(string(sym), "??") {
+			frames = append(frames, line)
+			continue
+		}
+		// llvm-symbolizer prints a function and location line pair per
+		// inlined frame.
+		sl := strings.Split(strings.TrimSpace(string(sym)), "\n")
+		for i := 0; i+1 < len(sl); i += 2 {
+			frames = append(frames, fmt.Sprintf("#%d %s %s at %s", len(frames), ss[1], sl[i], sl[i+1]))
+		}
+	}
+	if len(frames) == 0 {
+		return "", fmt.Errorf("gdb did not report a backtrace: %s", stderrExcerpt(string(out)))
+	}
+	return strings.Join(frames, "\n"), nil
+}
+
+// captureCrash turns the failure of an executable into a runtimeCrash, with
+// the backtrace of a crash which ran locally.
+func captureCrash(tc *Toolchain, exeFile string, spec *runSpec, iteration int, lf *llcFailure) *runtimeCrash {
+	rc := &runtimeCrash{iteration: iteration, msg: lf.msg, stderr: lf.stderr}
+	if command, _ := executeCommand(exeFile, nil); lf.msg.Signaled() && dev == nil && command == exeFile {
+		var err os.Error
+		if rc.backtrace, err = crashBacktrace(tc, exeFile, spec); err != nil {
+			rc.backtrace = "no backtrace: " + err.String()
+		}
+	}
+	return rc
+}
+
+// runtimeSignature identifies a runtime crash by its signal and the top
+// frames of its backtrace.
+func runtimeSignature(rc *runtimeCrash) string {
+	var frames []string
+	for _, line := range strings.Split(rc.backtrace, "\n") {
+		// "#N 0xPC function at file:line" or gdb's "#N 0xPC in function (...)".
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		name := fields[2]
+		if name == "in" && len(fields) > 3 {
+			name = fields[3]
+		}
+		if frames = append(frames, name); len(frames) == signatureFrames {
+			break
+		}
+	}
+	sig := fmt.Sprintf("run: %v", rc.msg)
+	if len(frames) > 0 {
+		sig += ": " + strings.Join(frames, " < ")
+	}
+	return sig
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// runSpec tells how to run the executable of a test with -run, so that
+// programs which read a seed or the time from their environment behave the
+// same with both toolchains.
+type runSpec struct {
+	Args []string `json:"args"`
+	// Env holds KEY=value pairs which are added to the environment.
+	Env []string `json:"env"`
+	// Stdin is a file to feed to the executable, relative to the manifest.
+	Stdin string `json:"stdin"`
+	stdin []byte
+}
+
+// runManifest maps the tests, by path or base name, to their run specs
+// with -run-manifest.
+var runManifest map[string]*runSpec
+
+// loadRunManifest reads a JSON object which maps tests to run specs, e.g.
+// {"rand.ll": {"args": ["-n", "100"], "env": ["SEED=1"], "stdin": "rand.in"}}.
+func loadRunManifest(filename string) (manifest map[string]*runSpec, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for test, spec := range manifest {
+		for _, kv := range spec.Env {
+			if strings.Index(kv, "=") <= 0 {
+				return nil, fmt.Errorf("%s: %s: env %q is not in KEY=value form", filename, test, kv)
+			}
+		}
+		if spec.Stdin == "" {
+			continue
+		}
+		if !filepath.IsAbs(spec.Stdin) {
+			spec.Stdin = path.Join(path.Dir(filename), spec.Stdin)
+		}
+		if spec.stdin, err = ioutil.ReadFile(spec.Stdin); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", filename, test, err)
+		}
+	}
+	return
+}
+
+// runSpecFor returns the run spec of the test, or an empty one.
+func runSpecFor(test string) *runSpec {
+	if spec, ok := runManifest[test]; ok {
+		return spec
+	}
+	if spec, ok := runManifest[path.Base(test)]; ok {
+		return spec
+	}
+	return new(runSpec)
+}
+
+// command returns the command which runs the executable with the arguments
+// and the environment of the spec; the stdin is up to the caller.
+func (s *runSpec) command(exeFile string) (command string, args []string) {
+	command, args = executeCommand(exeFile, s.Args)
+	if len(s.Env) == 0 {
+		return
+	}
+	args = append(append(append([]string(nil), s.Env...), command), args...)
+	return "env", args
+}
+
+// commandLine returns the command line which runs the executable with the
+// spec on a device's shell.
+func (s *runSpec) commandLine(remote string) string {
+	var words []string
+	for _, kv := range s.Env {
+		i := strings.Index(kv, "=")
+		words = append(words, kv[:i+1]+shellQuote(kv[i+1:]))
+	}
+	words = append(words, shellQuote(remote))
+	for _, arg := range s.Args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
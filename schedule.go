@@ -0,0 +1,199 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Without history, llc is assumed to need this many bytes of memory per byte
+// of input, plus a fixed baseline.
+const (
+	memPerInputByte = 40
+	memBaselineKB   = 64 * 1024
+)
+
+// Without history, llc is assumed to take this long per byte of input.
+const secondsPerInputByte = 1e-6
+
+// batch tells forEachTest to start no more tests once it is stopped.
+var batch struct {
+	mu      sync.Mutex
+	stopped bool
+}
+
+func stopBatch() {
+	batch.mu.Lock()
+	batch.stopped = true
+	batch.mu.Unlock()
+}
+
+func batchStopped() bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+	return batch.stopped
+}
+
+type memBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	free int64 // in kilobytes
+}
+
+func newMemBudget(kb int64) *memBudget {
+	b := &memBudget{free: kb}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *memBudget) acquire(kb int64) {
+	b.mu.Lock()
+	for b.free < kb {
+		b.cond.Wait()
+	}
+	b.free -= kb
+	b.mu.Unlock()
+}
+
+func (b *memBudget) release(kb int64) {
+	b.mu.Lock()
+	b.free += kb
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// estimateMem guesses the peak RSS of one llc run on the test, in kilobytes,
+// from previous runs if the cache knows them or from the size of the input.
+func estimateMem(test string) int64 {
+	if kb := cache.maxRSS(test); kb > 0 {
+		return kb
+	}
+	size, err := inputSize(test)
+	if err != nil {
+		return memBaselineKB
+	}
+	return memBaselineKB + size*memPerInputByte/1024
+}
+
+// estimateDuration guesses how long the test takes with both toolchains, in
+// seconds, from previous runs if the cache knows them or from the input size.
+func estimateDuration(test string) float64 {
+	if d := cache.duration(test); d > 0 {
+		return d
+	}
+	size, err := inputSize(test)
+	if err != nil {
+		return 0
+	}
+	return float64(size) * secondsPerInputByte
+}
+
+type byDuration struct {
+	order     []int
+	durations []float64
+}
+
+func (s byDuration) Len() int      { return len(s.order) }
+func (s byDuration) Swap(i, j int) { s.order[i], s.order[j] = s.order[j], s.order[i] }
+func (s byDuration) Less(i, j int) bool {
+	return s.durations[s.order[i]] > s.durations[s.order[j]]
+}
+
+// longestFirst returns the indices of tests ordered by descending expected
+// duration, so that a big test does not start last and delay the whole batch.
+func longestFirst(tests []string) []int {
+	s := byDuration{make([]int, len(tests)), make([]float64, len(tests))}
+	for i, tst := range tests {
+		s.order[i] = i
+		s.durations[i] = estimateDuration(tst)
+	}
+	sort.Sort(s)
+	return s.order
+}
+
+// runAt runs the test and records when it ran, to match it with the
+// -thermal-interval samples.
+func runAt(test string, run func(test string) *Result) *Result {
+	start := time.Nanoseconds()
+	res := run(test)
+	res.started, res.finished = start, time.Nanoseconds()
+	return res
+}
+
+// forEachTest calls run for every test, up to -j at a time and keeping the
+// estimated memory use within -max-mem, and passes the results to emit in
+// the order of tests. With -j, the longest tests are started first. watch,
+// if not nil, is called as each result completes, so that it may stop the
+// batch without waiting for the tests before it. Neither emit nor watch is
+// ever called concurrently. Once the batch is stopped, no more tests are
+// started and the results of the tests which completed are emitted, skipping
+// those which did not run.
+func forEachTest(tests []string, run func(test string) *Result, watch func(res *Result),
+	emit func(i int, res *Result)) {
+	if *jobs <= 1 {
+		for i, tst := range tests {
+			if batchStopped() {
+				return
+			}
+			res := runAt(tst, run)
+			if watch != nil {
+				watch(res)
+			}
+			emit(i, res)
+		}
+		return
+	}
+	var budget *memBudget
+	limit := int64(*maxMem) * 1024
+	if limit > 0 {
+		budget = newMemBudget(limit)
+	}
+	sem := make(chan bool, *jobs)
+	results := make([]*Result, len(tests))
+	done := make([]bool, len(tests))
+	next := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, i := range longestFirst(tests) {
+		tst := tests[i]
+		sem <- true
+		if batchStopped() {
+			break
+		}
+		mem := int64(0)
+		if budget != nil {
+			// Both toolchains run one after another, so one estimate is enough.
+			if mem = estimateMem(tst); mem > limit {
+				mem = limit
+			}
+			budget.acquire(mem)
+		}
+		wg.Add(1)
+		go func(i int, tst string, mem int64) {
+			res := runAt(tst, run)
+			if budget != nil {
+				budget.release(mem)
+			}
+			<-sem
+			mu.Lock()
+			if watch != nil {
+				watch(res)
+			}
+			results[i], done[i] = res, true
+			for next < len(tests) && done[next] {
+				emit(next, results[next])
+				results[next] = nil
+				next++
+			}
+			mu.Unlock()
+			wg.Done()
+		}(i, tst, mem)
+	}
+	wg.Wait()
+	// After a stop, the tests which did not start leave gaps.
+	for ; next < len(tests); next++ {
+		if done[next] {
+			emit(next, results[next])
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"http"
+	"json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// jobOptions are the flags which a job may set; the others are fixed for the
+// daemon.
+var jobOptions = map[string]bool{
+	"thresholds":      true,
+	"repeat":          true,
+	"skip-identical":  true,
+	"two-phase":       true,
+	"padding":         true,
+	"compressed-size": true,
+	"per-function":    true,
+	"function-diff":   true,
+	"strip-debug":     true,
+	"llc-args":        true,
+	"timeout":         true,
+}
+
+// Job is a comparison requested over HTTP.
+type Job struct {
+	ID      int               `json:"id"`
+	T1      string            `json:"t1"`
+	T2      string            `json:"t2"`
+	Tests   []string          `json:"tests"`
+	Options map[string]string `json:"options,omitempty"`
+	State   string            `json:"state"`
+	Error   string            `json:"error,omitempty"`
+	Results []*Record         `json:"results,omitempty"`
+}
+
+type jobServer struct {
+	mu    sync.Mutex
+	jobs  []*Job
+	queue chan *Job
+}
+
+// check rejects the malformed jobs without reading the flags, which the
+// worker may be changing.
+func (job *Job) check() (err os.Error) {
+	if len(job.Tests) == 0 {
+		return os.NewError("no tests")
+	}
+	for i, spec := range []string{job.T1, job.T2} {
+		if spec == "" {
+			return fmt.Errorf("t%d is not specified", i+1)
+		}
+	}
+	for name, value := range job.Options {
+		if !jobOptions[name] {
+			return fmt.Errorf("option %q can not be set per job", name)
+		}
+		if name == "thresholds" {
+			if _, err = parseThresholds(value); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// validate sets up the toolchains of the job. It reads the flags, so only the
+// worker calls it, after setting the job's options.
+func (job *Job) validate() (tcs [2]*Toolchain, err os.Error) {
+	if err = job.check(); err != nil {
+		return
+	}
+	for i, spec := range []string{job.T1, job.T2} {
+		if tcs[i], err = parseToolchain(fmt.Sprintf("t%d", i+1), spec); err != nil {
+			return
+		}
+	}
+	if tcs[0].Name == tcs[1].Name {
+		return tcs, fmt.Errorf("both toolchains are named %q", tcs[0].Name)
+	}
+	for _, tc := range tcs {
+		if err = tc.fetch(); err != nil {
+			return
+		}
+		if err = tc.preflight(); err != nil {
+			return
+		}
+	}
+	err = checkVersionSkew(tcs)
+	return
+}
+
+// run runs the job with its options set as flags, restoring the flags
+// afterwards.
+func (s *jobServer) run(job *Job) {
+	saved := make(map[string]string)
+	defer func() {
+		for name, value := range saved {
+			flag.Set(name, value)
+		}
+	}()
+	for name, value := range job.Options {
+		saved[name] = flag.Lookup(name).Value.String()
+		if !flag.Set(name, value) {
+			s.finish(job, nil, fmt.Errorf("invalid value %q for option %s", value, name))
+			return
+		}
+	}
+	tcs, err := job.validate()
+	if err != nil {
+		s.finish(job, nil, err)
+		return
+	}
+	run, rep, err := compareTests(tcs, job.Tests)
+	if err != nil {
+		s.finish(job, nil, err)
+		return
+	}
+	rep.markNeverPrinted()
+	var records []*Record
+	for _, res := range append(rep.results, rep.failed...) {
+		records = append(records, run.record(res))
+	}
+	s.finish(job, records, nil)
+}
+
+func (s *jobServer) finish(job *Job, records []*Record, err os.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.Results = records
+	job.State = jobDone
+	if err != nil {
+		job.State = jobFailed
+		job.Error = err.String()
+	}
+}
+
+func (s *jobServer) worker() {
+	for job := range s.queue {
+		s.mu.Lock()
+		job.State = jobRunning
+		s.mu.Unlock()
+		s.run(job)
+	}
+}
+
+func (s *jobServer) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON: %v", err)
+	}
+}
+
+// serveJobs lists the jobs on GET and queues a new job on POST.
+func (s *jobServer) serveJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		s.mu.Lock()
+		jobs := append([]*Job(nil), s.jobs...)
+		s.mu.Unlock()
+		s.writeJSON(w, http.StatusOK, jobs)
+	case "POST":
+		job := new(Job)
+		if err := json.NewDecoder(r.Body).Decode(job); err != nil {
+			http.Error(w, err.String(), http.StatusBadRequest)
+			return
+		}
+		if err := job.check(); err != nil {
+			http.Error(w, err.String(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		job.ID = len(s.jobs) + 1
+		job.State = jobQueued
+		job.Error, job.Results = "", nil
+		select {
+		case s.queue <- job:
+			s.jobs = append(s.jobs, job)
+		default:
+			s.mu.Unlock()
+			http.Error(w, "the job queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		s.mu.Unlock()
+		s.writeJSON(w, http.StatusCreated, job)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveJob returns the state and the results of /jobs/<id>.
+func (s *jobServer) serveJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Path[len("/jobs/"):])
+	var job *Job
+	s.mu.Lock()
+	if err == nil && id >= 1 && id <= len(s.jobs) {
+		job = s.jobs[id-1]
+	}
+	s.mu.Unlock()
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, job)
+}
+
+// serveCommand runs a daemon which accepts comparison jobs over HTTP and runs
+// them one at a time.
+func serveCommand(fs *flag.FlagSet, args []string) {
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	queueSize := fs.Int("queue", 100, "Maximum number of queued jobs")
+	fs.Parse(args)
+	s := &jobServer{queue: make(chan *Job, *queueSize)}
+	go s.worker()
+	http.HandleFunc("/jobs", s.serveJobs)
+	http.HandleFunc("/jobs/", s.serveJob)
+	log.Printf("Listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Fatalf("http.ListenAndServe: %v", err)
+	}
+}
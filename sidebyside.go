@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+)
+
+const sideBySideHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 8px; }
+div.panes { display: flex; height: 90vh; }
+div.pane { flex: 1; overflow: auto; border: 1px solid #ccc; font-family: monospace; white-space: pre; }
+div.pane div { height: 1.3em; line-height: 1.3em; }
+span.num { display: inline-block; width: 5em; color: #999; text-align: right; padding-right: 8px; }
+div.del { background: #fdd; }
+div.add { background: #dfd; }
+div.empty { background: #eee; }
+</style>
+<script>
+function sync(from, to) {
+  if (to.scrollTop != from.scrollTop) {
+    to.scrollTop = from.scrollTop;
+  }
+  if (to.scrollLeft != from.scrollLeft) {
+    to.scrollLeft = from.scrollLeft;
+  }
+}
+</script>
+</head>
+<body>
+<h1>%s</h1>
+<div class="panes">
+`
+
+type sideRow struct {
+	lines [2]string
+	nums  [2]int // 0 if the side has no line in this row
+}
+
+// sideBySideRows aligns the diff into rows, pairing the removed and added
+// lines of each change.
+func sideBySideRows(ops []diffOp) (rows []sideRow) {
+	var nums [2]int
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			nums[0]++
+			nums[1]++
+			rows = append(rows, sideRow{[2]string{ops[i].Line, ops[i].Line}, nums})
+			i++
+			continue
+		}
+		var del, add []string
+		for ; i < len(ops) && ops[i].Kind != ' '; i++ {
+			if ops[i].Kind == '-' {
+				del = append(del, ops[i].Line)
+			} else {
+				add = append(add, ops[i].Line)
+			}
+		}
+		for k := 0; k < len(del) || k < len(add); k++ {
+			var row sideRow
+			if k < len(del) {
+				nums[0]++
+				row.lines[0], row.nums[0] = del[k], nums[0]
+			}
+			if k < len(add) {
+				nums[1]++
+				row.lines[1], row.nums[1] = add[k], nums[1]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// writeSideBySide writes an HTML page with both assemblies of the test side by
+// side, the changed lines highlighted.
+func writeSideBySide(filename string, tcs [2]*Toolchain, test string, ops []diffOp) os.Error {
+	var buf bytes.Buffer
+	title := html.EscapeString(fmt.Sprintf("%s: %s vs %s", test, tcs[0].Name, tcs[1].Name))
+	fmt.Fprintf(&buf, sideBySideHead, title, title)
+	rows := sideBySideRows(ops)
+	for side := 0; side < 2; side++ {
+		fmt.Fprintf(&buf, "<div class=\"pane\" id=\"pane%d\" onscroll=\"sync(this, document.getElementById('pane%d'))\">",
+			side, 1-side)
+		for _, row := range rows {
+			class := ""
+			switch {
+			case row.nums[side] == 0:
+				class = "empty"
+			case row.nums[1-side] == 0 || row.lines[0] != row.lines[1]:
+				class = [2]string{"del", "add"}[side]
+			}
+			num := ""
+			if row.nums[side] > 0 {
+				num = fmt.Sprint(row.nums[side])
+			}
+			fmt.Fprintf(&buf, "<div class=\"%s\"><span class=\"num\">%s</span>%s</div>", class, num,
+				html.EscapeString(row.lines[side]))
+		}
+		fmt.Fprintf(&buf, "</div>\n")
+	}
+	fmt.Fprintf(&buf, "</div>\n</body>\n</html>\n")
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+func checkCrash(tc *Toolchain, test string) (err os.Error) {
+	_, _, err = tc.driver().Compile(tc, test, crashCheckRun)
+	return
+}
+
+func crashedOrHung(status string) bool {
+	return status == statusCrash || status == statusTimeout
+}
+
+// runCrashOnly runs every test once with both toolchains and prints how many
+// tests got each pair of statuses and the tests which crash or time out with
+// exactly one toolchain. It returns whether there were any such tests.
+func runCrashOnly(tcs [2]*Toolchain, tests []string) bool {
+	counts := make(map[string]int)
+	var oneSided []*Result
+	forEachTest(tests, func(test string) *Result {
+		if !*onlyRegressions {
+			fmt.Printf("Running test: %s\n", test)
+		}
+		res := &Result{Test: test}
+		for i, tc := range tcs {
+			err := checkCrash(tc, test)
+			res.Statuses[i] = statusOf(err)
+			if err != nil && res.Crash == nil {
+				res.Crash = crashOf(&testError{tc, test, err})
+			}
+		}
+		return res
+	}, nil, func(i int, res *Result) {
+		counts[res.Statuses[0]+"/"+res.Statuses[1]]++
+		if crashedOrHung(res.Statuses[0]) != crashedOrHung(res.Statuses[1]) {
+			oneSided = append(oneSided, res)
+		}
+	})
+
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Printf("\n%s/%s\ttests\n", tcs[0].Name, tcs[1].Name)
+	for _, k := range keys {
+		fmt.Printf("%s\t%d\n", k, counts[k])
+	}
+	fmt.Printf("\n%d tests crash or time out with exactly one toolchain:\ntest\t%s\t%s\tsignature\n",
+		len(oneSided), tcs[0].Name, tcs[1].Name)
+	for _, res := range oneSided {
+		signature := ""
+		if res.Crash != nil {
+			signature = res.Crash.Signature
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", path.Base(res.Test), res.Statuses[0], res.Statuses[1], signature)
+	}
+	return len(oneSided) > 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// The counters behind Stats.AsmInstrs and Stats.StackSpace.
+const (
+	asmInstrsKey  = "asm-printer - Number of machine instrs printed"
+	stackSpaceKey = "pei - Number of bytes used for stack in all functions"
+)
+
+// statsDialect tells how the -stats output of a range of LLVM releases
+// names what the metrics know by the names of the older releases.
+type statsDialect struct {
+	// since is the first major version which prints the dialect.
+	since int
+	// passRenames maps the pass names of the dialect to the older ones.
+	passRenames map[string]string
+}
+
+// statsDialects are sorted by since.
+var statsDialects = []statsDialect{
+	{0, nil},
+	// PrologEpilogInserter's debug type was pei before LLVM 5.
+	{5, map[string]string{"prologepilog": "pei"}},
+}
+
+// statsDialect returns the dialect of the toolchain's version, or the
+// newest one if the version is not known.
+func (tc *Toolchain) statsDialect() *statsDialect {
+	major := tc.majorVersion()
+	i := len(statsDialects) - 1
+	if major >= 0 {
+		for i = 0; i+1 < len(statsDialects) && statsDialects[i+1].since <= major; i++ {
+		}
+	}
+	return &statsDialects[i]
+}
+
+// counterAliases maps counters, by "<pass> - <description>" key or by pass
+// name, to the names they are compared under, from -counter-aliases.
+var counterAliases map[string]string
+
+// loadCounterAliases reads one "<from> => <to>" alias per line, where both
+// are either pass names or "<pass> - <description>" counter keys, e.g.
+// "regalloc - Number of reloads inserted => regalloc - Number of loads added".
+func loadCounterAliases(filename string) (aliases map[string]string, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	aliases = make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		kv := strings.SplitN(line, "=>", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<from> => <to>\"", filename, i+1)
+		}
+		from, to := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if from == "" || to == "" || strings.Contains(from, " - ") != strings.Contains(to, " - ") {
+			return nil, fmt.Errorf("%s:%d: alias %q does not map a pass to a pass or a counter to a counter",
+				filename, i+1, line)
+		}
+		aliases[from] = to
+	}
+	return
+}
+
+// counterKey returns the key of a -stats line in Stats.Counters, with the
+// pass name as the older releases print it and the -counter-aliases applied.
+func (d *statsDialect) counterKey(pass, desc string) string {
+	if old, ok := d.passRenames[pass]; ok {
+		pass = old
+	}
+	if alias, ok := counterAliases[pass]; ok {
+		pass = alias
+	}
+	key := pass + " - " + strings.TrimSpace(desc)
+	if alias, ok := counterAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// warnMissingStats warns once per toolchain if the compiler printed no
+// statistics or no pass timings although it was asked to, which usually
+// means a release build without LLVM_ENABLE_STATS or an output format
+// parseTestOutput does not know; the metrics would be 0 for it.
+func warnMissingStats(tc *Toolchain, mode runMode, asm string, stats *Stats) {
+	if tc.Driver == "gcc" {
+		return
+	}
+	var missing []string
+	if mode.stats && len(stats.Counters) == 0 && strings.TrimSpace(asm) != "" {
+		missing = append(missing, "-stats")
+	}
+	if mode.timePasses && stats.Seconds == 0 && stats.PassTimes == nil {
+		missing = append(missing, "--time-passes")
+	}
+	if len(missing) == 0 {
+		return
+	}
+	tc.missingStats.Do(func() {
+		log.Printf("WARNING: %s (LLVM %s) printed nothing parseable for %s; its metrics from them will be 0",
+			tc.Name, tc.Version, strings.Join(missing, " and "))
+	})
+}
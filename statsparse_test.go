@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+const oldStatsOutput = `===-------------------------------------------------------------------------===
+                          ... Statistics Collected ...
+===-------------------------------------------------------------------------===
+
+ 12 asm-printer - Number of machine instrs printed
+ 64 pei         - Number of bytes used for stack in all functions
+===-------------------------------------------------------------------------===
+                      ... Pass execution timing report ...
+===-------------------------------------------------------------------------===
+  Total Execution Time: 0.0400 seconds (0.0410 wall clock)
+
+   ---User Time---   --System Time--   --User+System--   ---Wall Time---  --- Name ---
+   0.0100 ( 50.0%)   0.0100 ( 50.0%)   0.0200 ( 50.0%)   0.0210 ( 51.2%)  X86 DAG->DAG Instruction Selection
+   0.0200 (100.0%)   0.0200 (100.0%)   0.0400 (100.0%)   0.0410 (100.0%)  Total
+`
+
+const newStatsOutput = `===-------------------------------------------------------------------------===
+                          ... Statistics Collected ...
+===-------------------------------------------------------------------------===
+
+ 12 asm-printer  - Number of machine instrs printed
+ 64 prologepilog - Number of bytes used for stack in all functions
+===-------------------------------------------------------------------------===
+                      ... Pass execution timing report ...
+===-------------------------------------------------------------------------===
+  Total Execution Time: 0.0400 seconds (0.0410 wall clock)
+
+   ---User Time---   --System Time--   --User+System--   ---Wall Time---  ---Instr---  --- Name ---
+   0.0100 ( 50.0%)   0.0100 ( 50.0%)   0.0200 ( 50.0%)   0.0210 ( 51.2%)  123456  X86 DAG->DAG Instruction Selection
+   0.0200 (100.0%)   0.0200 (100.0%)   0.0400 (100.0%)   0.0410 (100.0%)  246912  Total
+`
+
+func TestParseTestOutputDialects(t *testing.T) {
+	tests := []struct {
+		name, version, stderr string
+		stack                 int
+	}{
+		{"old layout", "3.0svn", oldStatsOutput, 64},
+		{"new layout", "17.0.1", newStatsOutput, 64},
+		// prologepilog is only renamed to pei from LLVM 5 on.
+		{"before the boundary", "4.0", newStatsOutput, 0},
+		{"at the boundary", "5.0", newStatsOutput, 64},
+		// An unknown version gets the newest dialect.
+		{"unknown version", "", newStatsOutput, 64},
+	}
+	for _, tt := range tests {
+		tc := &Toolchain{Name: "t1", Version: tt.version}
+		s := parseTestOutput(tc, tt.stderr)
+		if s.AsmInstrs != 12 {
+			t.Errorf("%s: AsmInstrs = %d, want 12", tt.name, s.AsmInstrs)
+		}
+		if s.StackSpace != tt.stack {
+			t.Errorf("%s: StackSpace = %d, want %d", tt.name, s.StackSpace, tt.stack)
+		}
+		if s.Seconds != 0.04 || s.WallSeconds != 0.041 {
+			t.Errorf("%s: Seconds, WallSeconds = %v, %v, want 0.04, 0.041", tt.name, s.Seconds, s.WallSeconds)
+		}
+		if wall := s.PassTimes["X86 DAG->DAG Instruction Selection"]; wall != 0.021 {
+			t.Errorf("%s: the wall time of instruction selection is %v, want 0.021", tt.name, wall)
+		}
+		if _, ok := s.PassTimes["Total"]; ok {
+			t.Errorf("%s: the Total row is taken for a pass", tt.name)
+		}
+	}
+}
+
+func TestStatsDialectSelection(t *testing.T) {
+	tests := []struct {
+		version string
+		since   int
+	}{
+		{"3.0svn", 0},
+		{"4.0.1", 0},
+		{"5.0", 5},
+		{"17.0.1", 5},
+		{"", 5},
+		{"trunk", 5},
+	}
+	for _, tt := range tests {
+		tc := &Toolchain{Name: "t1", Version: tt.version}
+		if d := tc.statsDialect(); d.since != tt.since {
+			t.Errorf("statsDialect(%q).since = %d, want %d", tt.version, d.since, tt.since)
+		}
+	}
+}
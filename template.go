@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path"
+	"template"
+)
+
+// templateData is the context of a -template report.
+type templateData struct {
+	Run        *Run
+	Toolchains [2]*Toolchain
+	// Metrics names the -columns metrics.
+	Metrics []string
+	Results []*Result
+	// Failed lists the tests which did not run with both toolchains.
+	Failed []*Result
+	// Matrix counts the tests by whether they failed with each toolchain.
+	Matrix [2][2]int
+}
+
+// templateFuncs are available in -template reports in addition to the
+// built-in functions such as html and printf.
+var templateFuncs = template.FuncMap{
+	"base": path.Base,
+	// value returns a metric of the stats of one toolchain.
+	"value": func(name string, s *Stats) float64 {
+		if m, ok := findMetric(name); ok && s != nil {
+			return m.value(s)
+		}
+		return 0
+	},
+	// delta returns the change of a metric in percent.
+	"delta": func(name string, res *Result) float64 {
+		if m, ok := findMetric(name); ok && res.Stats[0] != nil && res.Stats[1] != nil {
+			return deltaPct(m.value(res.Stats[0]), m.value(res.Stats[1]))
+		}
+		return 0
+	},
+	// format prints a metric value the way the report table does.
+	"format": func(name string, v float64) string {
+		if m, ok := findMetric(name); ok {
+			return m.format(v)
+		}
+		return ""
+	},
+}
+
+func writeTemplateReport(tmplFile, filename string, data *templateData) (err os.Error) {
+	var t *template.Template
+	if t, err = template.New(path.Base(tmplFile)).Funcs(templateFuncs).ParseFile(tmplFile); err != nil {
+		return
+	}
+	w := os.Stdout
+	if filename != "" {
+		if w, err = os.Create(filename); err != nil {
+			return
+		}
+		defer w.Close()
+	}
+	return t.Execute(w, data)
+}
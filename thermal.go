@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// thermalSample is the state of the CPUs at one point of the batch.
+type thermalSample struct {
+	at int64 // in nanoseconds
+	// mhz is the average current frequency of the CPUs, maxMHz their
+	// average scaling_max_freq, celsius the temperature of the hottest
+	// thermal zone.
+	mhz     float64
+	maxMHz  float64
+	celsius float64
+	// overTrip is set if a thermal zone passed one of its passive, hot or
+	// critical trip points.
+	overTrip bool
+	// throttles is the sum of the kernel's throttling event counters.
+	throttles int64
+	// The sources which the kernel provides.
+	hasFreq, hasTrips, hasThrottles bool
+}
+
+// thermalFreqDrop is the fraction of the reference frequency below which the
+// CPUs count as throttled.
+const thermalFreqDrop = 0.8
+
+func sysfsInt(filename string) (v int64, ok bool) {
+	v, err := strconv.Atoi64(readSysfs(filename))
+	return v, err == nil
+}
+
+func sampleThermal() (s thermalSample) {
+	s.at = time.Nanoseconds()
+	files, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_cur_freq")
+	n := 0
+	for _, f := range files {
+		if khz, ok := sysfsInt(f); ok {
+			s.mhz += float64(khz) / 1000
+			n++
+		}
+	}
+	if n > 0 {
+		s.mhz /= float64(n)
+		s.hasFreq = true
+	}
+	files, _ = filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_max_freq")
+	n = 0
+	for _, f := range files {
+		if khz, ok := sysfsInt(f); ok {
+			s.maxMHz += float64(khz) / 1000
+			n++
+		}
+	}
+	if n > 0 {
+		s.maxMHz /= float64(n)
+	}
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone[0-9]*")
+	for _, zone := range zones {
+		mc, ok := sysfsInt(path.Join(zone, "temp"))
+		if !ok {
+			continue
+		}
+		if float64(mc)/1000 > s.celsius {
+			s.celsius = float64(mc) / 1000
+		}
+		// Active trip points only start the fans.
+		trips, _ := filepath.Glob(path.Join(zone, "trip_point_[0-9]*_type"))
+		for _, trip := range trips {
+			switch readSysfs(trip) {
+			case "passive", "hot", "critical":
+			default:
+				continue
+			}
+			if limit, ok := sysfsInt(trip[:len(trip)-len("type")] + "temp"); ok && limit > 0 {
+				s.hasTrips = true
+				if mc >= limit {
+					s.overTrip = true
+				}
+			}
+		}
+	}
+	files, _ = filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/*_throttle_count")
+	for _, f := range files {
+		if c, ok := sysfsInt(f); ok {
+			s.throttles += c
+			s.hasThrottles = true
+		}
+	}
+	return
+}
+
+// throttled returns why the CPUs count as throttled in the interval from the
+// sample to the next one, or "" if they do not.
+func (from thermalSample) throttled(to, first thermalSample) string {
+	switch {
+	case to.hasThrottles && to.throttles > from.throttles:
+		return "throttling counters grew"
+	case to.overTrip:
+		return "passed a trip point"
+	case to.hasFreq && first.mhz > 0 && to.mhz < thermalFreqDrop*first.mhz:
+		return fmt.Sprintf("frequency dropped from %.0f MHz", first.mhz)
+	case to.hasFreq && to.maxMHz > 0 && to.mhz < thermalFreqDrop*to.maxMHz:
+		return fmt.Sprintf("frequency below the %.0f MHz maximum", to.maxMHz)
+	}
+	return ""
+}
+
+// thermalMonitor samples the CPUs every -thermal-interval seconds while the
+// tests run.
+type thermalMonitor struct {
+	mu      sync.Mutex
+	samples []thermalSample
+	stop    chan bool
+	done    chan bool
+}
+
+// startThermalMonitor returns nil if -thermal-interval is 0.
+func startThermalMonitor() *thermalMonitor {
+	thermalWindows = nil
+	if *thermalInterval <= 0 {
+		return nil
+	}
+	first := sampleThermal()
+	if !first.hasFreq && !first.hasTrips && !first.hasThrottles {
+		log.Printf("Warning: throttling detection is unavailable: the kernel reports no CPU frequency, " +
+			"thermal trip points or throttling counters")
+		return nil
+	}
+	m := &thermalMonitor{samples: []thermalSample{first}, stop: make(chan bool), done: make(chan bool)}
+	ticker := time.NewTicker(int64(*thermalInterval) * 1e9)
+	go func() {
+		defer func() { m.done <- true }()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s := sampleThermal()
+				m.mu.Lock()
+				m.samples = append(m.samples, s)
+				m.mu.Unlock()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// finish takes the last sample and marks the results of the tests which ran
+// while the CPUs were throttled: within a sampling interval in which the
+// kernel's throttling counters grew, a thermal zone passed a trip point or the
+// frequency dropped well below the first sample or scaling_max_freq.
+func (m *thermalMonitor) finish(results []*Result) {
+	if m == nil {
+		return
+	}
+	m.stop <- true
+	<-m.done
+	m.samples = append(m.samples, sampleThermal())
+	for i := 1; i < len(m.samples); i++ {
+		from, to := m.samples[i-1], m.samples[i]
+		why := from.throttled(to, m.samples[0])
+		if why == "" {
+			continue
+		}
+		thermalWindows = append(thermalWindows, thermalWindow{to, why})
+		for _, res := range results {
+			if res.started < to.at && res.finished > from.at {
+				res.Throttled = true
+			}
+		}
+	}
+}
+
+// thermalWindow is the sample which ended a throttled interval.
+type thermalWindow struct {
+	thermalSample
+	why string
+}
+
+var thermalWindows []thermalWindow
+
+// printThrottled lists the tests which ran while the CPUs were throttled,
+// whose timings are suspect, marking those which regressed.
+func printThrottled(results []*Result, limits map[string]float64) {
+	var throttled []*Result
+	for _, res := range results {
+		if res.Throttled {
+			throttled = append(throttled, res)
+		}
+	}
+	if len(throttled) == 0 {
+		return
+	}
+	fmt.Printf("\nThe CPUs were throttled while %d tests ran, their timings are suspect:\n", len(throttled))
+	for _, s := range thermalWindows {
+		fmt.Printf("throttled at %s: %.0f MHz, %.1f C (%s)\n", time.SecondsToLocalTime(s.at/1e9).Format("15:04:05"),
+			s.mhz, s.celsius, s.why)
+	}
+	fmt.Printf("test\tregressed\n")
+	for _, res := range throttled {
+		regressed := "no"
+		if res.severity(limits) > 0 {
+			regressed = "yes"
+		}
+		fmt.Printf("%s\t%s\n", path.Base(res.Test), regressed)
+	}
+}
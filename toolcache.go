@@ -0,0 +1,90 @@
+package main
+
+import (
+	"exec"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// buildScript builds and installs the llc, llvm-mc, llvm-objdump, opt, llvm-as
+// and llvm-stress of an LLVM commit; $1 is the LLVM git checkout, $2 the
+// commit and $3 the install prefix.
+const buildScript = `set -e
+src=$(mktemp -d)
+trap 'rm -rf "$src"' EXIT
+git -C "$1" archive --format=tar "$2" llvm | tar -x -C "$src"
+cmake -G Ninja -S "$src/llvm" -B "$src/build" -DCMAKE_BUILD_TYPE=Release -DLLVM_ENABLE_ASSERTIONS=ON \
+	-DCMAKE_INSTALL_PREFIX="$3" >&2
+ninja -C "$src/build" install-llc install-llvm-mc install-llvm-objdump install-opt install-llvm-as \
+	install-llvm-stress >&2
+`
+
+// fetch makes the toolchain of a commit:<hash> spec available in the
+// -toolchain-cache directory, where the toolchain of each commit is kept
+// once built or downloaded. It is a no-op for the other toolchains.
+func (tc *Toolchain) fetch() (err os.Error) {
+	if tc.Commit == "" || tc.Path != "" {
+		return
+	}
+	dir := filepath.Join(*toolchainCache, tc.Commit)
+	if _, err = os.Stat(dir); err != nil {
+		// Build or download next to the final directory, so that an
+		// interrupted attempt is not taken for a cached toolchain.
+		partial := dir + ".partial"
+		os.RemoveAll(partial)
+		if err = os.MkdirAll(partial, 0755); err != nil {
+			return
+		}
+		switch {
+		case *toolchainURL != "":
+			err = downloadToolchain(tc.Commit, partial)
+		case *llvmSrc != "":
+			log.Printf("Building LLVM at %s into %s", tc.Commit, dir)
+			var out []byte
+			if out, err = shellCommand(buildScript, *llvmSrc, tc.Commit, partial).CombinedOutput(); err != nil {
+				err = fmt.Errorf("building LLVM: %v, output: %s", err, lastLines(string(out), 20))
+			}
+		default:
+			err = fmt.Errorf("%s is not in %s; pass -toolchain-url or -llvm-src to get it", tc.Commit, *toolchainCache)
+		}
+		if err == nil {
+			err = os.Rename(partial, dir)
+		}
+		if err != nil {
+			os.RemoveAll(partial)
+			return fmt.Errorf("toolchain %s: %v", tc.Name, err)
+		}
+	}
+	tc.Path = dir
+	// A release tarball usually has a single top directory.
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) == 1 && entries[0].IsDirectory() {
+		tc.Path = filepath.Join(dir, entries[0].Name)
+	}
+	return tc.findTools()
+}
+
+// downloadToolchain downloads the -toolchain-url tarball of the commit with
+// fetchTest and unpacks it into dir.
+func downloadToolchain(commit, dir string) (err os.Error) {
+	var tarball string
+	if tarball, err = fetchTest(strings.Replace(*toolchainURL, "{commit}", commit, -1)); err != nil {
+		return
+	}
+	var out []byte
+	if out, err = exec.Command("tar", "-xf", tarball, "-C", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("tar -xf %s: %v, output: %s", tarball, err, out)
+	}
+	return
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"exec"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Toolchain struct {
+	Name string
+	Path string
+	// Driver is the compiler the toolchain is run with: llc, clang, gcc or rustc.
+	// Empty means llc.
+	Driver string
+	// Version is what llc -version reports, e.g. "3.0svn", after preflight.
+	Version string
+	// DefaultTarget is the target triple llc compiles for without -mtriple.
+	DefaultTarget string
+	// Substituted maps the llc flags which the toolchain does not support to
+	// what was passed instead, or to "" for the dropped ones.
+	Substituted map[string]string
+	// Tools maps tool names to the binaries to run instead, e.g. llc to
+	// llc-17 or to an absolute path, and Suffix is appended to the names of
+	// the other tools.
+	Tools  map[string]string
+	Suffix string
+	// Commit is the LLVM commit of a commit:<hash> toolchain, whose Path is
+	// only set once fetch got it into the -toolchain-cache.
+	Commit string
+	probes flagProbes
+	// binDir is the directory of the tools, found by findBinDir.
+	binDir string
+	// overhead holds the compile times of an empty module with -calibrate.
+	overhead Stats
+	// missingStats warns about the statistics the toolchain does not print.
+	missingStats sync.Once
+}
+
+var llvmVersionRegexp = regexp.MustCompile(`LLVM version ([0-9][0-9A-Za-z.]*)`)
+
+var defaultTargetRegexp = regexp.MustCompile(`Default target: ([^ \n]+)`)
+
+// preflightIR is a tiny module which any working llc compiles.
+const preflightIR = `define i32 @preflight(i32 %x) {
+entry:
+  %y = add i32 %x, 1
+  ret i32 %y
+}
+`
+
+// parseToolchain accepts either a plain install prefix or a comma-separated
+// list of key=value pairs, e.g. "name=trunk,path=/opt/llvm-trunk". The
+// sha256 key declares the SHA256 sum of the toolchain's compiler, which must
+// match, and the driver key selects the compiler. The tool.<name> keys and
+// the suffix key rename the tools, e.g. "path=/usr,suffix=-17" runs
+// /usr/bin/llc-17. The path PATH selects the tools found on $PATH, and
+// commit:<hash>, or the commit key, the toolchain of an LLVM commit, which
+// fetch gets.
+func parseToolchain(defaultName, spec string) (tc *Toolchain, err os.Error) {
+	tc = &Toolchain{Name: defaultName}
+	sum := ""
+	if strings.HasPrefix(spec, "commit:") {
+		tc.Commit = spec[len("commit:"):]
+		return
+	}
+	if !strings.Contains(spec, "=") {
+		tc.Path = spec
+		err = tc.findTools()
+		return
+	}
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("toolchain spec %q: %q is not in key=value form", spec, item)
+		}
+		switch kv[0] {
+		case "name":
+			tc.Name = kv[1]
+		case "path":
+			tc.Path = kv[1]
+		case "sha256":
+			sum = kv[1]
+		case "commit":
+			tc.Commit = kv[1]
+		case "suffix":
+			tc.Suffix = kv[1]
+		case "driver":
+			if _, ok := drivers[kv[1]]; !ok {
+				return nil, fmt.Errorf("toolchain spec %q: unknown driver %q", spec, kv[1])
+			}
+			tc.Driver = kv[1]
+		default:
+			if !strings.HasPrefix(kv[0], "tool.") || kv[0] == "tool." {
+				return nil, fmt.Errorf("toolchain spec %q: unknown key %q", spec, kv[0])
+			}
+			if tc.Tools == nil {
+				tc.Tools = make(map[string]string)
+			}
+			tc.Tools[kv[0][len("tool."):]] = kv[1]
+		}
+	}
+	if tc.Commit != "" && tc.Path == "" {
+		if sum != "" {
+			return nil, fmt.Errorf("toolchain spec %q: sha256 can not be checked for a commit", spec)
+		}
+		return
+	}
+	if tc.Path == "" && len(tc.Tools) == 0 {
+		return nil, fmt.Errorf("toolchain spec %q: neither path, commit nor tool.<name> is specified", spec)
+	}
+	if err = tc.findTools(); err != nil {
+		return nil, err
+	}
+	if sum != "" {
+		if err = verifyChecksum(tc.tool(tc.driver().Compiler()), sum); err != nil {
+			return nil, fmt.Errorf("toolchain %s: %v", tc.Name, err)
+		}
+	}
+	return
+}
+
+// preflight checks that the toolchain's llc is an executable which reports
+// its version and compiles a tiny module, so that a broken toolchain stops
+// the run before every test fails with it.
+func (tc *Toolchain) preflight() (err os.Error) {
+	if onMacOS {
+		if err = unquarantine(tc); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				err = codesignHint(err)
+			}
+		}()
+	}
+	if tc.driver().Compiler() != "llc" {
+		// Only llc is checked further than that it runs.
+		if _, err = tc.run(nil, tc.driver().Compiler(), "--version"); err != nil {
+			return fmt.Errorf("toolchain %s: %v", tc.Name, err)
+		}
+		return
+	}
+	llc := tc.tool("llc")
+	var fi *os.FileInfo
+	if fi, err = os.Stat(llc); err != nil {
+		return fmt.Errorf("toolchain %s has no llc: %v", tc.Name, err)
+	}
+	// Windows has no execute permission bits.
+	if !fi.IsRegular() || (!onWindows && fi.Mode&0111 == 0) {
+		return fmt.Errorf("toolchain %s: %s is not executable", tc.Name, llc)
+	}
+	var out []byte
+	if out, err = tc.run(nil, "llc", "-version"); err != nil {
+		return fmt.Errorf("toolchain %s: %v", tc.Name, err)
+	}
+	ss := llvmVersionRegexp.FindStringSubmatch(string(out))
+	if ss == nil {
+		return fmt.Errorf("toolchain %s: llc -version does not report an LLVM version: %s", tc.Name, out)
+	}
+	tc.Version = ss[1]
+	if ss = defaultTargetRegexp.FindStringSubmatch(string(out)); ss != nil {
+		tc.DefaultTarget = ss[1]
+	}
+	if _, err = tc.run([]byte(preflightIR), "llc", "-o", "-"); err != nil {
+		return fmt.Errorf("toolchain %s can not compile a trivial module: %v", tc.Name, err)
+	}
+	return
+}
+
+// majorVersion returns the first component of the LLVM version, or -1 if it
+// is not known.
+func (tc *Toolchain) majorVersion() int {
+	major, err := strconv.Atoi(strings.SplitN(tc.Version, ".", 2)[0])
+	if err != nil {
+		return -1
+	}
+	return major
+}
+
+// checkVersionSkew fails if the LLVM major versions of the toolchains differ
+// by more than -max-version-skew, unless -allow-version-skew is given, in
+// which case it only warns: the statistics of distant releases are often not
+// comparable.
+func checkVersionSkew(tcs [2]*Toolchain) os.Error {
+	m1, m2 := tcs[0].majorVersion(), tcs[1].majorVersion()
+	if m1 < 0 || m2 < 0 {
+		return nil
+	}
+	skew := m1 - m2
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= *maxVersionSkew {
+		return nil
+	}
+	msg := fmt.Sprintf("%s is LLVM %s and %s is LLVM %s, %d major versions apart", tcs[0].Name, tcs[0].Version,
+		tcs[1].Name, tcs[1].Version, skew)
+	if !*allowVersionSkew {
+		return fmt.Errorf("%s; pass -allow-version-skew to compare them anyway", msg)
+	}
+	log.Printf("WARNING: %s; their statistics may not be comparable", msg)
+	return nil
+}
+
+func (tc *Toolchain) String() string {
+	return tc.Name
+}
+
+// findTools sets the directory of the tools, looking the compiler up on
+// $PATH if the toolchain path is PATH.
+func (tc *Toolchain) findTools() (err os.Error) {
+	compiler := tc.toolName(tc.driver().Compiler())
+	if tc.Path != "PATH" {
+		tc.binDir = findBinDir(tc.Path, compiler)
+		return
+	}
+	var found string
+	if found, err = exec.LookPath(exeName(compiler)); err != nil {
+		return fmt.Errorf("toolchain %s: %s is not on PATH: %v", tc.Name, compiler, err)
+	}
+	tc.binDir = filepath.Dir(found)
+	tc.Path = filepath.Dir(tc.binDir)
+	return
+}
+
+// toolName returns the binary name of a tool, which may be an absolute path.
+func (tc *Toolchain) toolName(name string) string {
+	if t, ok := tc.Tools[name]; ok {
+		return t
+	}
+	return name + tc.Suffix
+}
+
+func (tc *Toolchain) tool(name string) string {
+	name = exeName(tc.toolName(name))
+	if filepath.IsAbs(name) {
+		return name
+	}
+	if tc.binDir == "" {
+		return filepath.Join(tc.Path, "bin", name)
+	}
+	return filepath.Join(tc.binDir, name)
+}
+
+// run runs one of the toolchain's tools with stdin as its input and returns
+// what it wrote to stdout.
+func (tc *Toolchain) run(stdin []byte, name string, args ...string) (stdout []byte, err os.Error) {
+	cmd := exec.Command(tc.tool(name), args...)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdin = bytes.NewBuffer(stdin)
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s %s: %v, stderr: %s", name, strings.Join(args, " "), err, errBuf.String())
+	}
+	return toLF(outBuf.Bytes()), nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// loadTestList reads a -skip or -xfail file, which has the format of -notes.
+func loadTestList(filename string) (tests map[string]string, err os.Error) {
+	if filename == "" {
+		return
+	}
+	if tests, err = loadNotes(filename); err != nil {
+		return nil, fmt.Errorf("loadNotes: %v", err)
+	}
+	return
+}
+
+// listed reports whether the test is in a list read by loadTestList, by its
+// path or base name.
+func listed(tests map[string]string, test string) bool {
+	_, ok := tests[test]
+	if !ok {
+		_, ok = tests[path.Base(test)]
+	}
+	return ok
+}
+
+// skipTests drops the tests listed in the -skip file.
+func skipTests(tests []string) (kept []string) {
+	skipped, err := loadTestList(*skipFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, tst := range tests {
+		if !listed(skipped, tst) {
+			kept = append(kept, tst)
+		}
+	}
+	return
+}
+
+// appendDecision records a triage decision as a "<test>: <reason>" line.
+func appendDecision(filename, test, reason string) (err os.Error) {
+	var f *os.File
+	if f, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+		return
+	}
+	defer f.Close()
+	if reason == "" {
+		reason = "triaged"
+	}
+	_, err = fmt.Fprintf(f, "%s: %s\n", test, reason)
+	return
+}
+
+// triageCommand steps through the divergent tests of a stored run, which are
+// neither skipped nor expected to diverge yet, and records the decisions into
+// the -skip and -xfail files. The tests run again with the toolchains of -t1
+// and -t2 to show their diff or to try other flags.
+func triageCommand(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: triage [flags] <results.json>[#run_id]\n")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	stored, results, err := loadRun(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("loadRun: %v", err)
+	}
+	tcs := parseToolchains()
+	setAsmTarget(targetTriple(tcs[0]))
+	for i, tc := range tcs {
+		if tc.Name != stored[i].Name {
+			log.Printf("WARNING: the run compared %s, not %s", stored[i].Name, tc.Name)
+		}
+	}
+	skipped, err := loadTestList(*skipFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	expected, err := loadTestList(*xfailFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var divergent []*Result
+	for _, res := range results {
+		if res.Err == nil && res.diverged() && !listed(skipped, res.Test) && !listed(expected, res.Test) {
+			divergent = append(divergent, res)
+		}
+	}
+	if len(divergent) == 0 {
+		fmt.Printf("No divergent tests to triage\n")
+		return
+	}
+	// The diff needs the assembly files, as with -difftool.
+	if *artifactDir == "" {
+		tmpDir, err := ioutil.TempDir("", "llvm-side-by-side")
+		if err != nil {
+			log.Fatalf("ioutil.TempDir: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		flag.Set("artifact-dir", tmpDir)
+	}
+	// The answers come from the terminal, like those of -difftool.
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		log.Fatalf("triage: %v", err)
+	}
+	defer tty.Close()
+	t := &triage{tcs: tcs, rep: &report{tcs: tcs}, in: bufio.NewReader(tty)}
+	for i, res := range divergent {
+		fmt.Printf("\n[%d/%d] %s\n", i+1, len(divergent), res.Test)
+		t.rep.printDetails(os.Stdout, res)
+		if !t.decide(res) {
+			return
+		}
+	}
+}
+
+type triage struct {
+	tcs [2]*Toolchain
+	rep *report
+	in  *bufio.Reader
+}
+
+func (t *triage) ask(prompt string) (answer string, ok bool) {
+	fmt.Print(prompt)
+	line, err := t.in.ReadString('\n')
+	return strings.TrimSpace(line), err == nil
+}
+
+// decide offers the actions on the test until one moves on, and reports
+// whether to go on with the next test.
+func (t *triage) decide(res *Result) bool {
+	for {
+		answer, ok := t.ask("d: diff, r: re-run with flags, R: reduce, x: expected, s: skip, n: next, q: quit? ")
+		if !ok {
+			return false
+		}
+		switch answer {
+		case "d":
+			t.diff(res.Test, "")
+		case "r":
+			extra, ok := t.ask("Extra llc flags: ")
+			if !ok {
+				return false
+			}
+			t.diff(res.Test, extra)
+		case "R":
+			if *reduceDir == "" {
+				fmt.Printf("Reducing needs -reduce-dir\n")
+				continue
+			}
+			reduced, err := reduceWith(t.tcs[0], t.tcs[1], res.Test, divergenceScript(t.tcs))
+			logReduced(res.Test, "divergence", reduced, err)
+		case "x", "s":
+			filename, flagName := *xfailFile, "-xfail"
+			if answer == "s" {
+				filename, flagName = *skipFile, "-skip"
+			}
+			if filename == "" {
+				fmt.Printf("Recording the decision needs %s\n", flagName)
+				continue
+			}
+			reason, ok := t.ask("Reason: ")
+			if !ok {
+				return false
+			}
+			if err := appendDecision(filename, res.Test, reason); err != nil {
+				log.Printf("appendDecision: %v", err)
+				continue
+			}
+			return true
+		case "n":
+			return true
+		case "q":
+			return false
+		default:
+			fmt.Printf("Unknown action %q\n", answer)
+		}
+	}
+	panic("unreachable")
+}
+
+// diff runs the test again with the extra llc flags and shows the diff of
+// the assemblies, in -difftool if it is set.
+func (t *triage) diff(test, extra string) {
+	if extra != "" {
+		saved := *llcFlags
+		flag.Set("llc-args", strings.TrimSpace(saved+" "+extra))
+		defer flag.Set("llc-args", saved)
+	}
+	stats, err := runBoth(t.tcs, test, deepRun)
+	if err != nil {
+		log.Printf("runBoth(%s): %v", test, err)
+		return
+	}
+	res := newResult(t.tcs, test, stats, sameOutput(stats))
+	if extra != "" {
+		if !res.diverged() {
+			fmt.Printf("The metrics do not diverge with %s\n", extra)
+		}
+		t.rep.printDetails(os.Stdout, res)
+	}
+	if res.Identical {
+		fmt.Printf("The assemblies are identical\n")
+		return
+	}
+	if *difftool != "" {
+		cmd := difftoolCommand(artifactPath(test, "."+t.tcs[0].Name+".s"), artifactPath(test, "."+t.tcs[1].Name+".s"))
+		if err = cmd.Run(); err != nil {
+			log.Printf("%s: %v", *difftool, err)
+		}
+		return
+	}
+	data, err := ioutil.ReadFile(res.DiffFile)
+	if err != nil {
+		log.Printf("ioutil.ReadFile: %v", err)
+		return
+	}
+	os.Stdout.Write(data)
+}
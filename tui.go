@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"exec"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tui shows the results in a table which updates as the tests finish. The
+// arrow keys or j and k select a test, enter shows its details and asm diff,
+// q goes back to the table and, once the tests are done, ends the session.
+type tui struct {
+	mu  sync.Mutex
+	rep *report
+	// term is the terminal; os.Stdout goes to /dev/null meanwhile, so
+	// that the progress messages do not garble the screen.
+	term, stdout *os.File
+	saved        string // the stty settings to restore
+	rows, cols   int
+	// results and failed are what the report got so far; the report's own
+	// lists change without t.mu.
+	results  []*Result
+	failed   int
+	selected int
+	top      int
+	// detail holds the lines of the detail view, or nil in the table.
+	detail []string
+	scroll int
+	logged []string
+	// logFile keeps the log messages for the guard, which restores the
+	// terminal and prints them if the process exits before finish, e.g.
+	// with log.Fatalf.
+	logFile *os.File
+	guard   *exec.Cmd
+	done    bool
+	stopped bool
+	quit    chan bool
+}
+
+// stty runs stty on the terminal of stdin.
+func stty(args ...string) (out string, err os.Error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	var data []byte
+	data, err = cmd.Output()
+	return strings.TrimSpace(string(data)), err
+}
+
+// tuiGuard waits for the process $0 to exit, then restores the stty
+// settings $1 and the screen and prints the log $2.
+const tuiGuard = `while kill -0 $0 2> /dev/null; do sleep 0.1; done
+stty "$1" < /dev/tty
+printf '\033[?25h\033[?1049l'
+cat "$2" >&2
+rm -f "$2"`
+
+// startTUI switches the terminal to raw mode and shows the results of the
+// report as they come.
+func startTUI(rep *report) *tui {
+	t := &tui{rep: rep, term: os.Stdout, stdout: os.Stdout, quit: make(chan bool)}
+	size, err := stty("size")
+	if err != nil {
+		log.Fatalf("-tui needs a terminal: %v", err)
+	}
+	if fields := strings.Fields(size); len(fields) == 2 {
+		t.rows, _ = strconv.Atoi(fields[0])
+		t.cols, _ = strconv.Atoi(fields[1])
+	}
+	if t.rows < 5 || t.cols < 20 {
+		t.rows, t.cols = 24, 80
+	}
+	if t.saved, err = stty("-g"); err != nil {
+		log.Fatalf("stty -g: %v", err)
+	}
+	if t.logFile, err = ioutil.TempFile("", "llvm-side-by-side-tui"); err != nil {
+		log.Fatalf("ioutil.TempFile: %v", err)
+	}
+	t.guard = exec.Command("sh", "-c", tuiGuard, strconv.Itoa(os.Getpid()), t.saved, t.logFile.Name())
+	t.guard.Stdout, t.guard.Stderr = os.Stdout, os.Stderr
+	if err = t.guard.Start(); err != nil {
+		log.Fatalf("starting the terminal guard: %v", err)
+	}
+	if _, err = stty("raw", "-echo"); err != nil {
+		log.Fatalf("stty raw: %v", err)
+	}
+	if os.Stdout, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+		log.Fatalf("os.OpenFile: %v", err)
+	}
+	log.SetOutput(t)
+	// The alternate screen keeps the scrollback as it was.
+	fmt.Fprint(t.term, "\x1b[?1049h\x1b[?25l")
+	t.draw()
+	go t.readKeys()
+	return t
+}
+
+// Write keeps the log messages, showing the last one in the status line.
+func (t *tui) Write(p []byte) (n int, err os.Error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if *bundleOut != "" {
+		bundleLog.Write(p)
+	}
+	t.logFile.Write(p)
+	t.logged = append(t.logged, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+func (t *tui) add(res *Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.results = append(t.results, res)
+	if res.Err != nil {
+		t.failed++
+	}
+	t.drawLocked()
+}
+
+// finish waits for q once the tests are done and restores the terminal.
+func (t *tui) finish() {
+	t.mu.Lock()
+	t.done = true
+	t.drawLocked()
+	t.mu.Unlock()
+	<-t.quit
+	t.guard.Process.Kill()
+	t.guard.Wait()
+	t.logFile.Close()
+	os.Remove(t.logFile.Name())
+	fmt.Fprint(t.term, "\x1b[?25h\x1b[?1049l")
+	stty(t.saved)
+	os.Stdout.Close()
+	os.Stdout = t.stdout
+	log.SetOutput(os.Stderr)
+	if *bundleOut != "" {
+		captureLog()
+	}
+	for _, line := range t.logged {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+func (t *tui) readKeys() {
+	buf := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(buf); n == 0 || err != nil {
+			return
+		}
+		key := string(buf)
+		if key == "\x1b" {
+			// Arrows and page keys are ESC [ <code>, with a ~ after
+			// the digit of the page keys.
+			seq := make([]byte, 2)
+			if n, _ := os.Stdin.Read(seq); n < 2 || seq[0] != '[' {
+				continue
+			}
+			switch seq[1] {
+			case 'A':
+				key = "k"
+			case 'B':
+				key = "j"
+			case '5', '6':
+				os.Stdin.Read(buf)
+				key = map[byte]string{'5': "b", '6': " "}[seq[1]]
+			}
+		}
+		if t.key(key) {
+			close(t.quit)
+			return
+		}
+	}
+}
+
+// key handles a key press and reports whether the session is over.
+func (t *tui) key(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	page := t.rows - 3
+	if t.detail != nil {
+		switch key {
+		case "j":
+			t.scroll++
+		case "k":
+			t.scroll--
+		case " ":
+			t.scroll += page
+		case "b":
+			t.scroll -= page
+		case "q", "h", "\x7f":
+			t.detail = nil
+		}
+		t.drawLocked()
+		return false
+	}
+	switch key {
+	case "j":
+		t.selected++
+	case "k":
+		t.selected--
+	case " ":
+		t.selected += page
+	case "b":
+		t.selected -= page
+	case "\r", "l":
+		if t.selected < len(t.results) {
+			t.detail, t.scroll = t.details(t.results[t.selected]), 0
+		}
+	case "q":
+		if t.done {
+			return true
+		}
+		if !t.stopped {
+			t.stopped = true
+			stopBatch()
+			t.logged = append(t.logged, "Stopping after the running tests; press q again when they are done")
+		}
+	case "\x03":
+		// Raw mode delivers ^C as a key.
+		return true
+	}
+	t.drawLocked()
+	return false
+}
+
+// details returns the lines of the detail view of a test: what printDetails
+// says about it and the diff of its assembly.
+func (t *tui) details(res *Result) []string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n\n", res.Test)
+	t.rep.printDetails(&buf, res)
+	switch {
+	case res.DiffFile != "":
+		if data, err := ioutil.ReadFile(res.DiffFile); err == nil {
+			fmt.Fprintf(&buf, "\n%s", data)
+		}
+	case res.Err == nil && !res.Identical && res.Stats[0].Asm != "":
+		ops := diffAsm(strings.Split(res.Stats[0].Asm, "\n"), strings.Split(res.Stats[1].Asm, "\n"))
+		fmt.Fprintf(&buf, "\n%s", unifiedDiff(t.rep.tcs[0].Name, t.rep.tcs[1].Name, ops, 3))
+	}
+	return strings.Split(strings.Replace(buf.String(), "\t", "  ", -1), "\n")
+}
+
+func (t *tui) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.drawLocked()
+}
+
+// line clips a line to the width of the terminal, then highlights it in
+// reverse video if asked to, so that the clipping cuts no escape code.
+func (t *tui) line(s string, highlight bool) string {
+	if len(s) > t.cols {
+		s = s[:t.cols]
+	}
+	if highlight {
+		s = "\x1b[7m" + s + "\x1b[0m"
+	}
+	return s + "\x1b[K\r\n"
+}
+
+func (t *tui) drawLocked() {
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	body := t.rows - 2
+	if t.detail != nil {
+		t.scroll = clamp(t.scroll, 0, len(t.detail)-body)
+		buf.WriteString(t.line("j/k: scroll  space/b: page  q: back", true))
+		for i := t.scroll; i < t.scroll+body; i++ {
+			if i < len(t.detail) {
+				buf.WriteString(t.line(t.detail[i], false))
+			} else {
+				buf.WriteString(t.line("", false))
+			}
+		}
+	} else {
+		t.selected = clamp(t.selected, 0, len(t.results)-1)
+		if t.selected < t.top {
+			t.top = t.selected
+		} else if t.selected >= t.top+body-1 {
+			t.top = t.selected - body + 2
+		}
+		state := "running"
+		if t.done {
+			state = "done, q to quit"
+		}
+		buf.WriteString(t.line(fmt.Sprintf("%d tests, %d failed (%s)  j/k: select  enter: details",
+			len(t.results), t.failed, state), true))
+		buf.WriteString(t.line(t.row(nil), false))
+		for i := t.top; i < t.top+body-1; i++ {
+			switch {
+			case i >= len(t.results):
+				buf.WriteString(t.line("", false))
+			case i == t.selected:
+				buf.WriteString(t.line(t.row(t.results[i]), true))
+			default:
+				buf.WriteString(t.line(t.row(t.results[i]), false))
+			}
+		}
+	}
+	status := ""
+	if len(t.logged) > 0 {
+		status = t.logged[len(t.logged)-1]
+	}
+	buf.WriteString(strings.TrimRight(t.line(status, false), "\r\n"))
+	t.term.Write(buf.Bytes())
+}
+
+// row formats a test as a row of the table, or the header if res is nil.
+func (t *tui) row(res *Result) string {
+	cells := []string{fmt.Sprintf("%-32.32s", "test"), fmt.Sprintf("%-12s", "status")}
+	if res != nil {
+		cells = []string{fmt.Sprintf("%-32.32s", path.Base(res.Test)), fmt.Sprintf("%-12s", res.status())}
+	}
+	for _, m := range t.rep.cs.metrics {
+		if res == nil {
+			cells = append(cells, fmt.Sprintf("%12.12s %7s", m.name, "delta%"))
+			continue
+		}
+		if res.Err != nil || m.missing(res.Stats[0]) || m.missing(res.Stats[1]) {
+			cells = append(cells, fmt.Sprintf("%12s %7s", "", ""))
+			continue
+		}
+		v1, v2 := m.value(res.Stats[0]), m.value(res.Stats[1])
+		cells = append(cells, fmt.Sprintf("%12.12s %+7.1f", m.format(v2), deltaPct(v1, v2)))
+	}
+	return strings.Join(cells, " ")
+}
+
+func clamp(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}